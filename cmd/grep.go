@@ -0,0 +1,58 @@
+/*
+Copyright © 2020 Joseph Saylor <doug@saylorsolutions.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/drognisep/sshtail/specfile"
+	"github.com/spf13/cobra"
+)
+
+var grepOptions specfile.GrepOptions
+var grepFormat string
+
+// grepCmd represents the grep command
+var grepCmd = &cobra.Command{
+	Use:   "grep",
+	Args:  cobra.ExactArgs(2),
+	Short: "Searches the files in a spec on every host for a pattern",
+	Long: `Searches the files in a spec on every host in parallel, and writes the matching
+lines tagged with their host and file. The pattern is an extended regular expression.
+	sshtail spec grep your-spec-name-here 'ERROR|FATAL'`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		formatter, err := specfile.NewFormatter(grepFormat)
+		if err != nil {
+			return err
+		}
+		specData, err := specfile.ReadSpecFile(args[0])
+		if err != nil {
+			return fmt.Errorf("Unable to parse config file '%s': %v", args[0], err)
+		}
+		grepOptions.Pattern = args[1]
+		cmd.SilenceUsage = true
+		return specfile.Grep(specData, clientOptions(), &grepOptions, formatter, os.Stdout)
+	},
+}
+
+func init() {
+	specCmd.AddCommand(grepCmd)
+
+	grepCmd.Flags().BoolVarP(&grepOptions.IgnoreCase, "ignore-case", "i", false, "Ignore case when matching the pattern")
+	grepCmd.Flags().BoolVarP(&grepOptions.Rotated, "rotated", "", false, "Also search rotated copies of each file, including gzip compressed ones")
+	grepCmd.Flags().StringVarP(&grepFormat, "format", "", specfile.FORMAT_TEXT, "Output format, one of text, jsonl, or logfmt")
+}
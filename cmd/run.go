@@ -16,14 +16,26 @@ limitations under the License.
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/drognisep/sshtail/specfile"
 	"github.com/spf13/cobra"
 )
 
 var outputFiles []string
+var outputFormat string
+var ordered bool
+var orderWindow time.Duration
+var includePatterns []string
+var excludePatterns []string
+var colorMode string
+var minLevel string
+var backfillLines int
+var since string
 
 // runCmd represents the run command
 var runCmd = &cobra.Command{
@@ -33,22 +45,82 @@ var runCmd = &cobra.Command{
 	Long: `Spec files have the extension .spec. A template can be created with
 	sshtail spec init your-spec-name-here`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		specData, err := specfile.ReadSpecFile(args[0])
+		formatter, err := specfile.NewFormatter(outputFormat)
 		if err != nil {
-			return fmt.Errorf("Unable to parse config file '%s': %v", args[0], err)
+			return err
 		}
-		writer, err := specfile.NewConsolidatedWriter(specData, os.Stdout)
+		colored, err := specfile.UseColor(colorMode, os.Stdout)
 		if err != nil {
 			return err
 		}
+		level := specfile.LEVEL_UNKNOWN
+		if minLevel != "" {
+			if level, err = specfile.ParseLevel(minLevel); err != nil {
+				return err
+			}
+		}
+		var sinceTime time.Time
+		if since != "" {
+			if sinceTime, err = specfile.ParseSince(since, time.Now()); err != nil {
+				return err
+			}
+		}
+		filter, err := specfile.NewFilter(includePatterns, excludePatterns)
+		if err != nil {
+			return fmt.Errorf("Invalid filter: %v", err)
+		}
+		if ordered && orderWindow <= 0 {
+			return errors.New("Order window must be greater than zero")
+		}
+		specData, err := specfile.ReadSpecFile(args[0])
+		if err != nil {
+			return fmt.Errorf("Unable to parse config file '%s': %v", args[0], err)
+		}
+		if cmd.Flags().Changed("lines") {
+			if backfillLines < 0 {
+				return errors.New("Lines to backfill cannot be negative")
+			}
+			for _, h := range specData.Hosts {
+				h.Backfill = backfillLines
+			}
+		}
+		cmd.SilenceUsage = true
+		// Output files are opened before connecting, so there's nothing to clean up if one of them can't be.
+		files := []*os.File{}
 		for _, s := range outputFiles {
 			file, err := os.OpenFile(s, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 			if err != nil {
+				for _, f := range files {
+					f.Close()
+				}
 				return fmt.Errorf("Failed to open and append to file '%s'", s)
 			}
+			files = append(files, file)
+		}
+		writer, err := specfile.NewConsolidatedWriter(specData, os.Stdout, clientOptions())
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+			return err
+		}
+		writer.SetFormatter(formatter)
+		writer.SetFilter(filter)
+		writer.SetColored(colored)
+		writer.SetMinLevel(level)
+		writer.SetSince(sinceTime)
+		if ordered {
+			writer.SetOrdered(orderWindow)
+		}
+		for _, file := range files {
 			writer.AddOutputFile(file)
 		}
-		writer.Start()
+		if err := writer.Start(); err != nil {
+			return err
+		}
+		if failed := writer.Failed(); len(failed) > 0 {
+			return fmt.Errorf("Tailing failed on %s", strings.Join(failed, ", "))
+		}
 		return nil
 	},
 }
@@ -66,4 +138,13 @@ func init() {
 	// is called directly, e.g.:
 	// runCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 	runCmd.Flags().StringSliceVarP(&outputFiles, "output", "o", []string{}, "Adds a file to the list of files that should have messages appended")
+	runCmd.Flags().StringVarP(&outputFormat, "format", "", specfile.FORMAT_TEXT, "Output format, one of text, jsonl, or logfmt")
+	runCmd.Flags().StringVarP(&colorMode, "color", "", specfile.COLOR_AUTO, "Color each host's prefix in text output, one of auto, always, or never")
+	runCmd.Flags().IntVarP(&backfillLines, "lines", "n", 0, "Write the last N lines of each file before following it, overriding backfill in the spec")
+	runCmd.Flags().StringVarP(&since, "since", "", "", "Start each file with the lines logged since a time, like 30m or '2006-01-02 15:04:05'")
+	runCmd.Flags().StringVarP(&minLevel, "min-level", "", "", "Hide lines with a log level below this one, like info or warn")
+	runCmd.Flags().BoolVarP(&ordered, "ordered", "", false, "Merge lines from all hosts in the order of their timestamps")
+	runCmd.Flags().DurationVarP(&orderWindow, "order-window", "", specfile.DEFAULT_ORDER_WINDOW, "How long lines are held to be put in order with --ordered")
+	runCmd.Flags().StringArrayVarP(&includePatterns, "include", "", []string{}, "Only write lines matching this regular expression, may be given more than once")
+	runCmd.Flags().StringArrayVarP(&excludePatterns, "exclude", "", []string{}, "Drop lines matching this regular expression, may be given more than once")
 }
@@ -17,10 +17,17 @@ package cmd
 
 import (
 	"errors"
+	"time"
 
+	"github.com/drognisep/sshtail/specfile"
 	"github.com/spf13/cobra"
 )
 
+var sshConfigFile string
+var allowPartial bool
+var connectTimeout time.Duration
+var connectConcurrency int
+
 // specCmd represents the spec command
 var specCmd = &cobra.Command{
 	Use:   "spec",
@@ -32,6 +39,16 @@ files to tail, and optionally what keys to use to connect with the server.`,
 	},
 }
 
+// clientOptions collects the flags that control how connections to spec hosts are established.
+func clientOptions() *specfile.ClientOptions {
+	return &specfile.ClientOptions{
+		SSHConfig:      sshConfigFile,
+		AllowPartial:   allowPartial,
+		ConnectTimeout: connectTimeout,
+		Concurrency:    connectConcurrency,
+	}
+}
+
 func init() {
 	rootCmd.AddCommand(specCmd)
 
@@ -44,4 +61,8 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// specCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	specCmd.PersistentFlags().StringVarP(&sshConfigFile, "ssh-config", "", "", "OpenSSH client config used to resolve host aliases (default is $HOME/.ssh/config)")
+	specCmd.PersistentFlags().BoolVarP(&allowPartial, "allow-partial", "", false, "Go ahead when some hosts can't be reached, retrying them in the background")
+	specCmd.PersistentFlags().DurationVarP(&connectTimeout, "connect-timeout", "", 0, "How long connecting to each host and the SSH handshake may take, overriding timeout in the spec (default 30s)")
+	specCmd.PersistentFlags().IntVarP(&connectConcurrency, "connect-concurrency", "", specfile.DEFAULT_CONNECT_CONCURRENCY, "How many hosts are connected to at once")
 }
@@ -3,8 +3,10 @@ module github.com/drognisep/sshtail
 go 1.14
 
 require (
+	github.com/kevinburke/ssh_config v1.2.0
 	github.com/mitchellh/go-homedir v1.1.0
 	github.com/pelletier/go-toml v1.9.5 // indirect
+	github.com/pkg/sftp v1.13.6
 	github.com/spf13/cobra v1.1.3
 	github.com/spf13/viper v1.15.0
 	golang.org/x/crypto v0.12.0
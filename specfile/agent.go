@@ -0,0 +1,149 @@
+/*
+Copyright © 2020 Joseph Saylor <doug@saylorsolutions.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package specfile
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+
+	homedir "github.com/mitchellh/go-homedir"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// dialAgent connects to the ssh-agent listening on SSH_AUTH_SOCK. The connection is left open, since the agent is
+// needed to sign each time a host is dialed.
+func dialAgent() (agent.ExtendedAgent, error) {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil, errors.New("SSH_AUTH_SOCK is not set, is ssh-agent running?")
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to connect to ssh-agent: %v", err)
+	}
+	return agent.NewClient(conn), nil
+}
+
+// authLoader creates the auth methods for each host's key spec, sharing a single agent connection between them.
+type authLoader struct {
+	agent      agent.ExtendedAgent
+	agentErr   error
+	agentTried bool
+	// defaultKeys are the default keys tried after the agent's keys, by path, so a passphrase is only asked for once
+	// however many hosts use the key. A nil signer is a key that can't be used.
+	defaultKeys map[string]ssh.Signer
+	// passphrase reads the passphrase for an encrypted default key. It's asked for on the terminal if this isn't set.
+	passphrase func(path string) ([]byte, error)
+}
+
+func (a *authLoader) loadAgent() (agent.ExtendedAgent, error) {
+	if !a.agentTried {
+		a.agentTried = true
+		a.agent, a.agentErr = dialAgent()
+	}
+	return a.agent, a.agentErr
+}
+
+// authMethods returns the ways to authenticate with the key spec. If no key was configured, then the agent's keys are
+// tried first if it's available, followed by the default key. They have to be offered by a single auth method, since
+// public key authentication is only attempted once.
+func (a *authLoader) authMethods(key *KeySpec) ([]ssh.AuthMethod, error) {
+	if key.Agent || key.fallback {
+		client, err := a.loadAgent()
+		if key.Agent {
+			if err != nil {
+				return nil, err
+			}
+			return []ssh.AuthMethod{ssh.PublicKeysCallback(client.Signers)}, nil
+		}
+		if err == nil {
+			signer := a.defaultKey(client, key.Path)
+			if signer == nil {
+				return []ssh.AuthMethod{ssh.PublicKeysCallback(client.Signers)}, nil
+			}
+			return []ssh.AuthMethod{ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+				signers, err := client.Signers()
+				if err != nil {
+					return []ssh.Signer{signer}, nil
+				}
+				return append(signers, signer), nil
+			})}, nil
+		}
+	}
+	keyAuth, err := LoadKey(key.Path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load key from %s: %v", key.Path, err)
+	}
+	return []ssh.AuthMethod{keyAuth}, nil
+}
+
+// defaultKey loads the default key to try after the agent's keys, or returns nil if it can't be used. A key that needs
+// a passphrase isn't decrypted if the agent holds it, since then it isn't needed.
+func (a *authLoader) defaultKey(client agent.ExtendedAgent, path string) ssh.Signer {
+	if signer, loaded := a.defaultKeys[path]; loaded {
+		return signer
+	}
+	if a.defaultKeys == nil {
+		a.defaultKeys = map[string]ssh.Signer{}
+	}
+	signer, err := loadSigner(path, nil)
+	if missing, ok := err.(*ssh.PassphraseMissingError); ok && !agentHolds(client, missing.PublicKey, path) {
+		passphrase := a.passphrase
+		if passphrase == nil {
+			passphrase = promptPassphrase
+		}
+		if signer, err = loadSigner(path, passphrase); err != nil {
+			statusf("Failed to load key from %s: %v\n", path, err)
+		}
+	}
+	if err != nil {
+		signer = nil
+	}
+	a.defaultKeys[path] = signer
+	return signer
+}
+
+// agentHolds reports whether the agent holds the key. If the public key isn't known, then it's read from the key's .pub
+// file, and the key is assumed not to be held if that can't be read either.
+func agentHolds(client agent.ExtendedAgent, pub ssh.PublicKey, path string) bool {
+	if pub == nil {
+		if expanded, err := homedir.Expand(path); err == nil {
+			path = expanded
+		}
+		data, err := ioutil.ReadFile(path + ".pub")
+		if err != nil {
+			return false
+		}
+		if pub, _, _, _, err = ssh.ParseAuthorizedKey(data); err != nil {
+			return false
+		}
+	}
+	keys, err := client.List()
+	if err != nil {
+		return false
+	}
+	for _, k := range keys {
+		if bytes.Equal(k.Marshal(), pub.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,232 @@
+/*
+Copyright © 2020 Joseph Saylor <doug@saylorsolutions.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package specfile
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// startTestAgent serves an in-process agent holding a new key on a socket named by SSH_AUTH_SOCK.
+func startTestAgent(t *testing.T) ssh.PublicKey {
+	dir, err := ioutil.TempDir("", "sshtail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	socket := filepath.Join(dir, "agent.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skipf("Unix sockets are not available: %v", err)
+	}
+
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	keyring := agent.NewKeyring()
+	if err = keyring.Add(agent.AddedKey{PrivateKey: priv}); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go agent.ServeAgent(keyring, conn)
+		}
+	}()
+
+	oldSocket := os.Getenv("SSH_AUTH_SOCK")
+	os.Setenv("SSH_AUTH_SOCK", socket)
+	t.Cleanup(func() {
+		os.Setenv("SSH_AUTH_SOCK", oldSocket)
+		l.Close()
+		os.RemoveAll(dir)
+	})
+	signer, _ := ssh.NewSignerFromKey(priv)
+	return signer.PublicKey()
+}
+
+// handshake authenticates with an in-process server that only accepts the given key.
+func handshake(t *testing.T, auth []ssh.AuthMethod, accepted ssh.PublicKey) error {
+	_, hostPriv, _ := ed25519.GenerateKey(rand.Reader)
+	hostSigner, _ := ssh.NewSignerFromKey(hostPriv)
+	serverConfig := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if bytes.Equal(key.Marshal(), accepted.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("Unknown key")
+		},
+	}
+	serverConfig.AddHostKey(hostSigner)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		serverConn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		conn, _, _, err := ssh.NewServerConn(serverConn, serverConfig)
+		if err == nil {
+			defer conn.Close()
+			conn.Wait()
+		}
+	}()
+	config := &ssh.ClientConfig{User: "me", Auth: auth, HostKeyCallback: ssh.InsecureIgnoreHostKey()}
+	client, err := ssh.Dial("tcp", l.Addr().String(), config)
+	if err != nil {
+		return err
+	}
+	return client.Close()
+}
+
+func TestAgentAuth(t *testing.T) {
+	agentKey := startTestAgent(t)
+
+	auth := &authLoader{}
+	methods, err := auth.authMethods(&KeySpec{Agent: true})
+	if err != nil {
+		t.Fatalf("Failed to load agent: %v", err)
+	}
+	if err = handshake(t, methods, agentKey); err != nil {
+		t.Errorf("Failed to authenticate with agent: %v", err)
+	}
+}
+
+func TestAgentFallback(t *testing.T) {
+	agentKey := startTestAgent(t)
+
+	auth := &authLoader{}
+	methods, err := auth.authMethods(&KeySpec{Path: "/no/such/key", fallback: true})
+	if err != nil {
+		t.Fatalf("Agent should be used when no key is configured: %v", err)
+	}
+	if err = handshake(t, methods, agentKey); err != nil {
+		t.Errorf("Failed to authenticate with agent: %v", err)
+	}
+
+	if _, err = auth.authMethods(&KeySpec{Path: "/no/such/key"}); err == nil {
+		t.Error("A configured key that doesn't exist should be an error")
+	}
+}
+
+func TestAgentWrongKey(t *testing.T) {
+	startTestAgent(t)
+
+	dir, err := ioutil.TempDir("", "sshtail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalECPrivateKey(priv)
+	path := filepath.Join(dir, "id_ecdsa")
+	if err = ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	signer, _ := ssh.NewSignerFromKey(priv)
+
+	auth := &authLoader{}
+	methods, err := auth.authMethods(&KeySpec{Path: path, fallback: true})
+	if err != nil {
+		t.Fatalf("Failed to load auth methods: %v", err)
+	}
+	if err = handshake(t, methods, signer.PublicKey()); err != nil {
+		t.Errorf("Default key should be tried when the agent doesn't hold the right key: %v", err)
+	}
+}
+
+func TestAgentEncryptedDefaultKey(t *testing.T) {
+	agentKey := startTestAgent(t)
+
+	dir, err := ioutil.TempDir("", "sshtail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalECPrivateKey(priv)
+	block, err := x509.EncryptPEMBlock(rand.Reader, "EC PRIVATE KEY", der, []byte("secret"), x509.PEMCipherAES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "id_ecdsa")
+	if err = ioutil.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	signer, _ := ssh.NewSignerFromKey(priv)
+
+	asked := 0
+	auth := &authLoader{passphrase: func(string) ([]byte, error) {
+		asked++
+		return []byte("secret"), nil
+	}}
+	for _, host := range []string{"host1", "host2"} {
+		methods, err := auth.authMethods(&KeySpec{Path: path, fallback: true})
+		if err != nil {
+			t.Fatalf("Failed to load auth methods for %s: %v", host, err)
+		}
+		if err = handshake(t, methods, signer.PublicKey()); err != nil {
+			t.Errorf("Encrypted default key should be tried when the agent doesn't hold it: %v", err)
+		}
+		if err = handshake(t, methods, agentKey); err != nil {
+			t.Errorf("Failed to authenticate with agent: %v", err)
+		}
+	}
+	if asked != 1 {
+		t.Errorf("Got:\n%d passphrase prompts\nWanted:\n1", asked)
+	}
+
+	// The agent holds the key in its .pub file, so there's no need to ask for the passphrase.
+	if err = ioutil.WriteFile(path+".pub", ssh.MarshalAuthorizedKey(agentKey), 0644); err != nil {
+		t.Fatal(err)
+	}
+	auth = &authLoader{passphrase: func(string) ([]byte, error) {
+		t.Error("Passphrase should not be asked for a key the agent holds")
+		return nil, errors.New("Not asked")
+	}}
+	if _, err = auth.authMethods(&KeySpec{Path: path, fallback: true}); err != nil {
+		t.Errorf("Failed to load auth methods: %v", err)
+	}
+}
+
+func TestAgentMissing(t *testing.T) {
+	oldSocket := os.Getenv("SSH_AUTH_SOCK")
+	os.Unsetenv("SSH_AUTH_SOCK")
+	defer os.Setenv("SSH_AUTH_SOCK", oldSocket)
+
+	auth := &authLoader{}
+	if _, err := auth.authMethods(&KeySpec{Agent: true}); err == nil {
+		t.Error("Agent auth without SSH_AUTH_SOCK should be an error")
+	}
+}
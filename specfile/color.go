@@ -0,0 +1,97 @@
+/*
+Copyright © 2020 Joseph Saylor <doug@saylorsolutions.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package specfile
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// Color modes for text output.
+const (
+	// COLOR_AUTO colors output when it's written to a terminal.
+	COLOR_AUTO   string = "auto"
+	COLOR_ALWAYS string = "always"
+	COLOR_NEVER  string = "never"
+)
+
+const colorReset string = "\x1b[0m"
+
+// colorNames are the colors that can be set for a host, as ANSI SGR parameters.
+var colorNames = map[string]string{
+	"red":            "31",
+	"green":          "32",
+	"yellow":         "33",
+	"blue":           "34",
+	"magenta":        "35",
+	"cyan":           "36",
+	"bright-red":     "91",
+	"bright-green":   "92",
+	"bright-yellow":  "93",
+	"bright-blue":    "94",
+	"bright-magenta": "95",
+	"bright-cyan":    "96",
+}
+
+// colorPalette is the order that hosts are assigned colors from, skipping black and white so every color shows up on
+// both light and dark terminals.
+var colorPalette = []string{"31", "32", "33", "34", "35", "36", "91", "92", "93", "94", "95", "96"}
+
+// UseColor decides whether output to the file should be colored in the given mode.
+func UseColor(mode string, out *os.File) (bool, error) {
+	switch mode {
+	case "", COLOR_AUTO:
+		if os.Getenv("NO_COLOR") != "" {
+			return false, nil
+		}
+		return terminal.IsTerminal(int(out.Fd())), nil
+	case COLOR_ALWAYS:
+		return true, nil
+	case COLOR_NEVER:
+		return false, nil
+	default:
+		return false, fmt.Errorf("Unknown color mode '%s', must be one of %s", mode, strings.Join([]string{COLOR_AUTO, COLOR_ALWAYS, COLOR_NEVER}, ", "))
+	}
+}
+
+// parseColor returns the escape sequence for a color name, or a number from the 256 color palette.
+func parseColor(color string) (string, error) {
+	if code, found := colorNames[color]; found {
+		return "\x1b[" + code + "m", nil
+	}
+	if n, err := strconv.Atoi(color); err == nil && n >= 0 && n <= 255 {
+		return fmt.Sprintf("\x1b[38;5;%dm", n), nil
+	}
+	return "", fmt.Errorf("Unknown color '%s', must be a color name or a number from 0 to 255", color)
+}
+
+// hostColor returns the escape sequence for the host's color. Unless a color is set, the color is picked using a hash
+// of the host's tag, so a host keeps the same color every time the spec is run.
+func hostColor(hostTag string, spec *HostSpec) string {
+	if spec.Color != "" {
+		if seq, err := parseColor(spec.Color); err == nil {
+			return seq
+		}
+	}
+	h := fnv.New32a()
+	h.Write([]byte(hostTag))
+	return "\x1b[" + colorPalette[h.Sum32()%uint32(len(colorPalette))] + "m"
+}
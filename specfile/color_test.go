@@ -0,0 +1,84 @@
+/*
+Copyright © 2020 Joseph Saylor <doug@saylorsolutions.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package specfile
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestHostColor(t *testing.T) {
+	spec := &HostSpec{}
+	if hostColor("host1", spec) != hostColor("host1", spec) {
+		t.Errorf("A host's color should be the same every time")
+	}
+
+	tests := []struct {
+		color string
+		want  string
+	}{
+		{"cyan", "\x1b[36m"},
+		{"bright-red", "\x1b[91m"},
+		{"208", "\x1b[38;5;208m"},
+	}
+	for _, tt := range tests {
+		if got := hostColor("host1", &HostSpec{Color: tt.color}); got != tt.want {
+			t.Errorf("Got:\n%q\nWanted:\n%q", got, tt.want)
+		}
+	}
+
+	invalid := HostSpec{Hostname: "host", File: "file", Color: "chartreuse"}
+	if err := invalid.Validate(); err == nil {
+		t.Errorf("Unknown color should not have passed validation")
+	}
+}
+
+func TestColoredText(t *testing.T) {
+	e := &Event{Host: "host1", Tag: "host1", Line: "a line"}
+	f := textFormatter{colors: map[string]string{"host1": "\x1b[36m"}}
+	want := "\x1b[36m[ host1 ]\x1b[0m a line\n"
+	if got := f.Format(e); got != want {
+		t.Errorf("Got:\n%q\nWanted:\n%q", got, want)
+	}
+}
+
+func TestUseColor(t *testing.T) {
+	f, err := ioutil.TempFile("", "sshtail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	tests := []struct {
+		mode string
+		want bool
+	}{
+		{COLOR_AUTO, false},
+		{COLOR_ALWAYS, true},
+		{COLOR_NEVER, false},
+	}
+	for _, tt := range tests {
+		got, err := UseColor(tt.mode, f)
+		if err != nil || got != tt.want {
+			t.Errorf("%s Got:\n%v %v\nWanted:\n%v", tt.mode, got, err, tt.want)
+		}
+	}
+	if _, err := UseColor("sometimes", f); err == nil {
+		t.Errorf("Unknown color mode should be an error")
+	}
+}
@@ -0,0 +1,46 @@
+/*
+Copyright © 2020 Joseph Saylor <doug@saylorsolutions.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package specfile
+
+import (
+	"io"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// commandFollower runs an arbitrary command on the remote host and follows its output, like 'kubectl logs -f' or
+// 'dmesg -w'.
+type commandFollower struct {
+	session *ssh.Session
+	command string
+	filter  *FilterSpec
+}
+
+func (c *commandFollower) follow(out io.Writer, errOut io.Writer) error {
+	c.session.Stdout = out
+	c.session.Stderr = errOut
+	return c.session.Run(c.remoteCommand())
+}
+
+// remoteCommand wraps the command so that its stderr is followed along with its stdout, and remote filters are applied
+// to both. The command is on a line of its own, so a trailing comment in it doesn't swallow the rest.
+func (c *commandFollower) remoteCommand() string {
+	return c.filter.filterCommand("{ " + c.command + "\n} 2>&1")
+}
+
+func (c *commandFollower) Close() error {
+	return c.session.Close()
+}
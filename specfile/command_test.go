@@ -0,0 +1,148 @@
+/*
+Copyright © 2020 Joseph Saylor <doug@saylorsolutions.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package specfile
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"os/exec"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestCommandSpec(t *testing.T) {
+	spec := &HostSpec{Hostname: "remote-host-1", Command: "dmesg -w"}
+	if err := spec.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if spec.Source != SOURCE_COMMAND {
+		t.Errorf("Got:\n%s\nWanted:\n%s", spec.Source, SOURCE_COMMAND)
+	}
+
+	for _, invalid := range []*HostSpec{
+		{Hostname: "remote-host-1", Command: "dmesg -w", File: "/var/log/syslog"},
+		{Hostname: "remote-host-1", Command: "dmesg -w", Source: SOURCE_JOURNAL},
+		{Hostname: "remote-host-1", Command: "dmesg -w", Mode: MODE_SFTP},
+		{Hostname: "remote-host-1", Command: "  ", Source: SOURCE_COMMAND},
+		{Hostname: "remote-host-1", Source: SOURCE_COMMAND},
+	} {
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("Invalid host spec %+v should be an error", invalid)
+		}
+	}
+}
+
+func TestCommandFollower(t *testing.T) {
+	filter, err := NewFilter(nil, []string{"health"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &commandFollower{command: "kubectl logs -f deploy/api # api pods", filter: filter}
+	want := "exec 3>&1; status=$( { { ( { kubectl logs -f deploy/api # api pods\n} 2>&1 ) 3>&- 4>&-; echo $? >&4; } | " +
+		"{ grep --line-buffered -v -E -e 'health'; } >&3 3>&- 4>&-; } 4>&1 ); (exit ${status:-1})"
+	if got := f.remoteCommand(); got != want {
+		t.Errorf("Got:\n%q\nWanted:\n%q", got, want)
+	}
+}
+
+// startTestShell starts an in-process SSH server that runs exec requests with the local shell, and connects to it.
+func startTestShell(t *testing.T) *ssh.Client {
+	_, hostPriv, _ := ed25519.GenerateKey(rand.Reader)
+	hostSigner, _ := ssh.NewSignerFromKey(hostPriv)
+	serverConfig := &ssh.ServerConfig{NoClientAuth: true}
+	serverConfig.AddHostKey(hostSigner)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		defer l.Close()
+		serverConn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		conn, chans, reqs, err := ssh.NewServerConn(serverConn, serverConfig)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		go ssh.DiscardRequests(reqs)
+		for newChannel := range chans {
+			channel, requests, err := newChannel.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer channel.Close()
+				for req := range requests {
+					var payload struct{ Command string }
+					if req.Type != "exec" || ssh.Unmarshal(req.Payload, &payload) != nil {
+						req.Reply(false, nil)
+						continue
+					}
+					req.Reply(true, nil)
+					cmd := exec.Command("sh", "-c", payload.Command)
+					cmd.Stdout = channel
+					cmd.Stderr = channel.Stderr()
+					status := 0
+					if err := cmd.Run(); err != nil {
+						status = 255
+						if exitErr, ok := err.(*exec.ExitError); ok {
+							status = exitErr.ExitCode()
+						}
+					}
+					channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(status)}))
+					return
+				}
+			}()
+		}
+	}()
+	client, err := ssh.Dial("tcp", l.Addr().String(), &ssh.ClientConfig{HostKeyCallback: ssh.InsecureIgnoreHostKey()})
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	return client
+}
+
+func TestFilteredCommandFails(t *testing.T) {
+	if _, err := exec.LookPath("grep"); err != nil {
+		t.Skip("grep is needed to filter the command")
+	}
+	client := startTestShell(t)
+	defer client.Close()
+	filter, err := NewFilter(nil, []string{"health"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &commandFollower{session: session, command: "echo boom; echo health; exit 3", filter: filter}
+	var out bytes.Buffer
+	got := commandTermination(f.follow(&out, ioutil.Discard))
+	if got.Reason != TERM_EXIT || got.ExitCode != 3 {
+		t.Errorf("Got:\n%v\nWanted:\nexited with status 3", got)
+	}
+	if out.String() != "boom\n" {
+		t.Errorf("Got:\n%q\nWanted:\n%q", out.String(), "boom\n")
+	}
+}
@@ -0,0 +1,225 @@
+/*
+Copyright © 2020 Joseph Saylor <doug@saylorsolutions.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package specfile
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// dialTarget is an address to connect to, and the configuration used to authenticate with it.
+type dialTarget struct {
+	addr   string
+	config *ssh.ClientConfig
+}
+
+// dialVia connects to the target, tunneling through the via connection unless it's nil. The target's Timeout limits
+// both connecting and the SSH handshake, so a host that never answers can't hold up the rest.
+func dialVia(via *ssh.Client, target *dialTarget) (*ssh.Client, error) {
+	timeout := target.config.Timeout
+	var conn net.Conn
+	var err error
+	if via == nil {
+		conn, err = net.DialTimeout("tcp", target.addr, timeout)
+	} else {
+		conn, err = tunnel(via, target.addr, timeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var timer *time.Timer
+	if timeout > 0 {
+		timer = time.AfterFunc(timeout, func() { conn.Close() })
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, target.addr, target.config)
+	if timer != nil && !timer.Stop() {
+		if err == nil {
+			c.Close()
+		}
+		return nil, fmt.Errorf("Timed out after %v waiting for the SSH handshake", timeout)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// tunnel opens a connection to the address through the via connection. Opening the tunnel has no deadline of its own,
+// so it's given up on after the timeout, and a connection that arrives late is closed.
+func tunnel(via *ssh.Client, addr string, timeout time.Duration) (net.Conn, error) {
+	if timeout <= 0 {
+		return via.Dial("tcp", addr)
+	}
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	opened := make(chan result)
+	abandoned := make(chan struct{})
+	go func() {
+		conn, err := via.Dial("tcp", addr)
+		select {
+		case opened <- result{conn, err}:
+		case <-abandoned:
+			if conn != nil {
+				conn.Close()
+			}
+		}
+	}()
+	select {
+	case r := <-opened:
+		return r.conn, r.err
+	case <-time.After(timeout):
+		close(abandoned)
+		return nil, fmt.Errorf("Timed out after %v connecting to %s through the jump host", timeout, addr)
+	}
+}
+
+// JUMP_CHECK_INTERVAL is how long a jump host connection that was just made or answered a probe is used without being
+// probed again, so hosts connecting at the same time don't each wait on a probe.
+const JUMP_CHECK_INTERVAL time.Duration = 5 * time.Second
+
+// jumpPool shares jump host connections between all of the hosts in a spec that connect through them. Every connection
+// it makes, to a jump host or to a host, is kept alive with keepalive.
+type jumpPool struct {
+	mu        sync.Mutex
+	clients   map[string]*ssh.Client
+	checked   map[string]time.Time
+	hops      map[string]*jumpHop
+	closed    bool
+	keepalive KeepaliveSpec
+}
+
+// jumpHop is a check or dial of a jump host that's in progress. Hosts that need the same jump host while it's going on
+// wait for it and share its result, including a failure. Done is closed once the result is set.
+type jumpHop struct {
+	done   chan struct{}
+	client *ssh.Client
+	err    error
+}
+
+func newJumpPool(keepalive KeepaliveSpec) *jumpPool {
+	return &jumpPool{
+		clients:   map[string]*ssh.Client{},
+		checked:   map[string]time.Time{},
+		hops:      map[string]*jumpHop{},
+		keepalive: keepalive,
+	}
+}
+
+// dial connects to the target through each of the jump hosts in order. The name is used to report a connection that
+// stopped answering keepalives.
+func (p *jumpPool) dial(jumps []*dialTarget, target *dialTarget, name string) (*ssh.Client, error) {
+	var via *ssh.Client
+	if len(jumps) > 0 {
+		var err error
+		if via, err = p.bastion(jumps); err != nil {
+			return nil, err
+		}
+	}
+	client, err := dialVia(via, target)
+	if err != nil {
+		return nil, err
+	}
+	keepAlive(client, p.keepalive, "[ "+name+" ]")
+	return client, nil
+}
+
+// bastion returns the connection to the last jump host in the chain. A jump host is connected to once for all of the
+// hosts that reach it through the same hops, and is reconnected if it stops answering.
+func (p *jumpPool) bastion(jumps []*dialTarget) (*ssh.Client, error) {
+	var via *ssh.Client
+	key := ""
+	for _, jump := range jumps {
+		key += jump.config.User + "@" + jump.addr + ","
+		client, err := p.hop(key, via, jump)
+		if err != nil {
+			return nil, err
+		}
+		via = client
+	}
+	return via, nil
+}
+
+// hop returns the connection to the jump host with the key, checking or dialing it if needed. Only one check or dial
+// of a jump host happens at a time, and nothing is locked while it does, so hosts behind other jump hosts don't wait.
+func (p *jumpPool) hop(key string, via *ssh.Client, jump *dialTarget) (*ssh.Client, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, errHostClosed
+	}
+	if h := p.hops[key]; h != nil {
+		p.mu.Unlock()
+		<-h.done
+		return h.client, h.err
+	}
+	client := p.clients[key]
+	if client != nil && time.Since(p.checked[key]) < JUMP_CHECK_INTERVAL {
+		p.mu.Unlock()
+		return client, nil
+	}
+	h := &jumpHop{done: make(chan struct{})}
+	p.hops[key] = h
+	p.mu.Unlock()
+
+	if client != nil && probe(client, PROBE_TIMEOUT) != nil {
+		client.Close()
+		client = nil
+	}
+	var err error
+	if client == nil {
+		if client, err = dialVia(via, jump); err != nil {
+			err = fmt.Errorf("Failed to connect to jump host %s: %v", jump.addr, err)
+		} else {
+			keepAlive(client, p.keepalive, "Jump host "+jump.addr)
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.hops, key)
+	if err == nil && p.closed {
+		client.Close()
+		client, err = nil, errHostClosed
+	}
+	if err == nil {
+		p.clients[key] = client
+		p.checked[key] = time.Now()
+	} else {
+		delete(p.clients, key)
+	}
+	h.client, h.err = client, err
+	close(h.done)
+	return client, err
+}
+
+// Close disconnects from all of the jump hosts.
+func (p *jumpPool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for key, client := range p.clients {
+		client.Close()
+		delete(p.clients, key)
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+/*
+Copyright © 2020 Joseph Saylor <doug@saylorsolutions.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package specfile
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DockerSpec selects the containers followed for a host. Container names containers by name or ID, and Label selects
+// every running container that has all of the labels, each written as 'key' or 'key=value'. Containers with the labels
+// are looked for again every Rescan interval, like glob patterns.
+type DockerSpec struct {
+	Container StringList `json:"container" yaml:"container"`
+	Label     StringList `json:"label" yaml:"label"`
+}
+
+// Validate checks the DockerSpec for errors.
+func (d *DockerSpec) Validate() error {
+	if len(d.Container) == 0 && len(d.Label) == 0 {
+		return errors.New("Docker spec must have a container or label")
+	}
+	for _, c := range d.Container {
+		if c == "" {
+			return errors.New("Docker spec cannot have a blank container")
+		}
+	}
+	for _, l := range d.Label {
+		if l == "" || strings.HasPrefix(l, "=") {
+			return fmt.Errorf("Docker spec has an invalid label '%s'", l)
+		}
+	}
+	return nil
+}
+
+// containersCommand creates a remote command that lists the names and statuses of the containers with all of the
+// labels, including those that are stopped.
+func containersCommand(labels []string) string {
+	args := []string{"docker ps -a --format '{{.Names}} {{.Status}}'"}
+	for _, l := range labels {
+		args = append(args, "--filter", shellQuote("label="+l))
+	}
+	return strings.Join(args, " ")
+}
+
+// parseContainers separates the containers listed by the containers command into those that are running and those
+// that aren't.
+func parseContainers(lines []string) (running []string, stopped []string) {
+	for _, l := range lines {
+		name, status := l, ""
+		if i := strings.IndexByte(l, ' '); i >= 0 {
+			name, status = l[:i], l[i+1:]
+		}
+		if strings.HasPrefix(status, "Up") {
+			running = append(running, name)
+		} else {
+			stopped = append(stopped, name)
+		}
+	}
+	return running, stopped
+}
+
+// listContainers lists the containers on the remote host that match the pair's labels, separating the running
+// containers from the stopped ones.
+func listContainers(pair *ClientFilePair) ([]string, []string, error) {
+	client, err := liveClient(pair)
+	if err != nil {
+		return nil, nil, err
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error establishing session: %v", err)
+	}
+	defer session.Close()
+	out, err := session.Output(containersCommand(pair.Spec.Docker.Label))
+	if err != nil {
+		return nil, nil, err
+	}
+	running, stopped := parseContainers(outputLines(out))
+	return running, stopped, nil
+}
+
+// dockerFollower uses the remote 'docker' executable to follow the output of a container, both stdout and stderr.
+type dockerFollower struct {
+	session   *ssh.Session
+	container string
+	fromStart bool
+	backfill  int
+	marker    string
+	since     time.Time
+}
+
+func (d *dockerFollower) follow(out io.Writer, errOut io.Writer) error {
+	d.session.Stdout = out
+	d.session.Stderr = errOut
+	return d.session.Run(d.command())
+}
+
+// command creates the remote command that follows the container. 'docker logs' stops when the container does, so once
+// it's running again, its output is followed again from the second the previous 'docker logs' stopped in. To backfill,
+// the last lines, or the lines logged since the start time, are written, then the marker, then the output is followed
+// from the second before the backfill was written. Lines are timestamped, so the writer drops any that are repeated.
+func (d *dockerFollower) command() string {
+	name := shellQuote(d.container)
+	reattach := fmt.Sprintf(`while :; do s=$(date +%%s); sleep 1; until [ "$(docker inspect -f '{{.State.Running}}' %[1]s 2>/dev/null)" = true ]; do sleep 1; done; `+
+		`docker logs -f -t --since "$s" %[1]s 2>&1; done`, name)
+	if d.marker != "" {
+		history := fmt.Sprintf("--tail %d", d.backfill)
+		if !d.since.IsZero() {
+			history = fmt.Sprintf("--since %d", d.since.Unix())
+		}
+		return fmt.Sprintf(`s=$(date +%%s); docker logs -t %[1]s %[2]s 2>&1; printf '%%s\n' %[3]s; docker logs -f -t --since "$s" %[2]s 2>&1; %[4]s`,
+			history, name, shellQuote(d.marker), reattach)
+	}
+	tail := "--tail 0 "
+	if d.fromStart {
+		tail = ""
+	}
+	return fmt.Sprintf("docker logs -f -t %s%s 2>&1; %s", tail, name, reattach)
+}
+
+func (d *dockerFollower) Close() error {
+	return d.session.Close()
+}
+
+// decodeDocker separates the timestamp added by 'docker logs' from the rest of the line, and records the container the
+// line is from. Lines without a timestamp, like errors from docker itself, are left as they are.
+func decodeDocker(e *Event, container string) {
+	e.Fields = map[string]string{"container": container}
+	ts, rest := e.Line, ""
+	if i := strings.IndexByte(e.Line, ' '); i >= 0 {
+		ts, rest = e.Line[:i], e.Line[i+1:]
+	}
+	if logged, err := time.Parse(time.RFC3339Nano, ts); err == nil {
+		e.Logged = logged
+		e.Line = rest
+	}
+}
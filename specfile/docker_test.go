@@ -0,0 +1,125 @@
+/*
+Copyright © 2020 Joseph Saylor <doug@saylorsolutions.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package specfile
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestDockerSpec(t *testing.T) {
+	spec := &HostSpec{}
+	err := yaml.Unmarshal([]byte("hostname: remote-host-1\nsource: docker\ndocker:\n  container: api\n  label: [app=web, tier]\n"), spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := spec.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	want := DockerSpec{Container: StringList{"api"}, Label: StringList{"app=web", "tier"}}
+	if !reflect.DeepEqual(spec.Docker, want) {
+		t.Errorf("Got:\n%v\nWanted:\n%v", spec.Docker, want)
+	}
+
+	for _, invalid := range []*HostSpec{
+		{Hostname: "remote-host-1", Source: SOURCE_DOCKER},
+		{Hostname: "remote-host-1", Source: SOURCE_DOCKER, File: "/var/log/syslog", Docker: DockerSpec{Container: StringList{"api"}}},
+		{Hostname: "remote-host-1", Source: SOURCE_DOCKER, Docker: DockerSpec{Container: StringList{""}}},
+		{Hostname: "remote-host-1", Source: SOURCE_DOCKER, Docker: DockerSpec{Label: StringList{"=web"}}},
+	} {
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("Invalid host spec %+v should be an error", invalid)
+		}
+	}
+}
+
+func TestDockerCommand(t *testing.T) {
+	want := "docker ps -a --format '{{.Names}} {{.Status}}' --filter 'label=app=web' --filter 'label=tier'"
+	if got := containersCommand([]string{"app=web", "tier"}); got != want {
+		t.Errorf("Got:\n%s\nWanted:\n%s", got, want)
+	}
+
+	f := &dockerFollower{container: "api"}
+	got := f.command()
+	for _, want := range []string{"docker logs -f -t --tail 0 'api' 2>&1; while :;", `docker logs -f -t --since "$s" 'api' 2>&1; done`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Got:\n%s\nWanted it to contain:\n%s", got, want)
+		}
+	}
+	f.fromStart = true
+	if got := f.command(); !strings.HasPrefix(got, "docker logs -f -t 'api' 2>&1;") {
+		t.Errorf("Got:\n%s\nWanted to follow from the start", got)
+	}
+
+	f = &dockerFollower{container: "api", backfill: 10, marker: "marker"}
+	want = `s=$(date +%s); docker logs -t --tail 10 'api' 2>&1; printf '%s\n' 'marker'; docker logs -f -t --since "$s" 'api' 2>&1; while :;`
+	if got := f.command(); !strings.HasPrefix(got, want) {
+		t.Errorf("Got:\n%s\nWanted it to start with:\n%s", got, want)
+	}
+	f.since = time.Unix(1588334400, 0)
+	if got := f.command(); !strings.Contains(got, "docker logs -t --since 1588334400 'api'") {
+		t.Errorf("Got:\n%s\nWanted a start time", got)
+	}
+}
+
+func TestParseContainers(t *testing.T) {
+	running, stopped := parseContainers([]string{"web-1 Up 5 minutes", "web-2 Exited (0) 3 seconds ago", "web-3 Up 2 hours (Paused)", "web-4 Created"})
+	if want := []string{"web-1", "web-3"}; !reflect.DeepEqual(running, want) {
+		t.Errorf("Got:\n%v\nWanted:\n%v", running, want)
+	}
+	if want := []string{"web-2", "web-4"}; !reflect.DeepEqual(stopped, want) {
+		t.Errorf("Got:\n%v\nWanted:\n%v", stopped, want)
+	}
+}
+
+func TestDecodeDocker(t *testing.T) {
+	e := &Event{Line: "2020-05-01T12:00:00.123456789Z GET /health 200"}
+	decodeDocker(e, "api")
+	want := &Event{
+		Logged: time.Date(2020, 5, 1, 12, 0, 0, 123456789, time.UTC),
+		Line:   "GET /health 200",
+		Fields: map[string]string{"container": "api"},
+	}
+	if !reflect.DeepEqual(e, want) {
+		t.Errorf("Got:\n%+v\nWanted:\n%+v", e, want)
+	}
+
+	e = &Event{Line: "Error: No such container: api"}
+	decodeDocker(e, "api")
+	if e.Line != "Error: No such container: api" || !e.Logged.IsZero() {
+		t.Errorf("Got:\n%+v\nWanted the line unchanged", e)
+	}
+}
+
+func TestWriterDockerRepeats(t *testing.T) {
+	spec := &HostSpec{Hostname: "remote-host-1", Source: SOURCE_DOCKER, Docker: DockerSpec{Container: StringList{"api"}}}
+	if err := spec.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	pair := &ClientFilePair{HostTag: "host1", Mode: spec.Mode, Tag: "host1:api", Spec: spec, Container: "api"}
+	ch := make(chan *Event, 10)
+	w := NewTailChannelWriter(pair, ch)
+	defer w.Flush()
+
+	w.Write([]byte("2020-05-01T12:00:00.1Z first\n2020-05-01T12:00:00.2Z second\n"))
+	// Attaching again repeats the lines from the second the container stopped in.
+	w.Write([]byte("2020-05-01T12:00:00.1Z first\n2020-05-01T12:00:00.2Z second\n2020-05-01T12:00:05Z third\n"))
+	expectLines(t, ch, "[ host1:api ] first\n", "[ host1:api ] second\n", "[ host1:api ] third\n")
+}
@@ -0,0 +1,190 @@
+/*
+Copyright © 2020 Joseph Saylor <doug@saylorsolutions.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package specfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Output formats supported by the ConsolidatedWriter.
+const (
+	FORMAT_TEXT   string = "text"
+	FORMAT_JSONL  string = "jsonl"
+	FORMAT_LOGFMT string = "logfmt"
+)
+
+// STREAM_STDERR is the stream of events written to stderr by a remote command, like errors from tail.
+const STREAM_STDERR string = "stderr"
+
+// Event is a single line received from a file on a remote host. Host is the tag of the host in the spec, and Tag is
+// what's used to identify the file in text output. Level is only set once the line's level has been detected.
+// Backfill is set for lines that were already in the file when tailing started. Logged and Fields are only set by
+// sources that record when a line was logged and other details about it, like the unit of a journal entry. Stream is
+// STREAM_STDERR for diagnostics the remote command wrote to stderr, and blank for the lines being followed.
+type Event struct {
+	Host     string
+	Tag      string
+	Hostname string
+	File     string
+	Time     time.Time
+	Logged   time.Time
+	Line     string
+	Level    Level
+	Backfill bool
+	Fields   map[string]string
+	Stream   string
+
+	levelStart int
+	levelEnd   int
+}
+
+// Formatter turns an event into the text written to the output, including the trailing newline.
+type Formatter interface {
+	Format(e *Event) string
+}
+
+// NewFormatter creates a Formatter for the named output format.
+func NewFormatter(format string) (Formatter, error) {
+	switch format {
+	case "", FORMAT_TEXT:
+		return textFormatter{}, nil
+	case FORMAT_JSONL:
+		return jsonlFormatter{}, nil
+	case FORMAT_LOGFMT:
+		return logfmtFormatter{}, nil
+	default:
+		return nil, fmt.Errorf("Unknown output format '%s', must be one of %s", format, strings.Join([]string{FORMAT_TEXT, FORMAT_JSONL, FORMAT_LOGFMT}, ", "))
+	}
+}
+
+// textFormatter writes lines prefixed with their tag, like '[ host1 ] line', or '[ host1 ~] line' for backfill and
+// '[ host1 !] line' for stderr. If there are colors, then the prefix is colored with the color of the event's host.
+type textFormatter struct {
+	colors map[string]string
+}
+
+func (f textFormatter) Format(e *Event) string {
+	prefix := "[ " + e.Tag + " ]"
+	if e.Stream == STREAM_STDERR {
+		prefix = "[ " + e.Tag + " !]"
+	} else if e.Backfill {
+		prefix = "[ " + e.Tag + " ~]"
+	}
+	if color, found := f.colors[e.Host]; found {
+		return fmt.Sprintf("%s%s%s %s\n", color, prefix, colorReset, highlightLevel(e))
+	}
+	return fmt.Sprintf("%s %s\n", prefix, e.Line)
+}
+
+// jsonRecord is the shape of an event in JSON Lines output.
+type jsonRecord struct {
+	Time     string            `json:"time"`
+	Host     string            `json:"host"`
+	Hostname string            `json:"hostname"`
+	File     string            `json:"file,omitempty"`
+	Logged   string            `json:"logged,omitempty"`
+	Level    string            `json:"level,omitempty"`
+	Backfill bool              `json:"backfill,omitempty"`
+	Stream   string            `json:"stream,omitempty"`
+	Fields   map[string]string `json:"fields,omitempty"`
+	Line     string            `json:"line"`
+}
+
+// jsonlFormatter writes each event as a JSON object on its own line.
+type jsonlFormatter struct{}
+
+func (jsonlFormatter) Format(e *Event) string {
+	record := jsonRecord{
+		Time:     e.Time.Format(time.RFC3339Nano),
+		Host:     e.Host,
+		Hostname: e.Hostname,
+		File:     e.File,
+		Level:    e.Level.String(),
+		Backfill: e.Backfill,
+		Stream:   e.Stream,
+		Fields:   e.Fields,
+		Line:     e.Line,
+	}
+	if !e.Logged.IsZero() {
+		record.Logged = e.Logged.Format(time.RFC3339Nano)
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(record)
+	return buf.String()
+}
+
+// logfmtFormatter writes each event as space separated key=value pairs. Fields are written in order of their keys.
+type logfmtFormatter struct{}
+
+func (logfmtFormatter) Format(e *Event) string {
+	var sb strings.Builder
+	writeLogfmtPair(&sb, "time", e.Time.Format(time.RFC3339Nano))
+	writeLogfmtPair(&sb, "host", e.Host)
+	writeLogfmtPair(&sb, "hostname", e.Hostname)
+	if e.File != "" {
+		writeLogfmtPair(&sb, "file", e.File)
+	}
+	if !e.Logged.IsZero() {
+		writeLogfmtPair(&sb, "logged", e.Logged.Format(time.RFC3339Nano))
+	}
+	if e.Level != LEVEL_UNKNOWN {
+		writeLogfmtPair(&sb, "level", e.Level.String())
+	}
+	if e.Backfill {
+		writeLogfmtPair(&sb, "backfill", "true")
+	}
+	if e.Stream != "" {
+		writeLogfmtPair(&sb, "stream", e.Stream)
+	}
+	keys := make([]string, 0, len(e.Fields))
+	for k := range e.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		writeLogfmtPair(&sb, k, e.Fields[k])
+	}
+	writeLogfmtPair(&sb, "line", e.Line)
+	sb.WriteString("\n")
+	return sb.String()
+}
+
+func writeLogfmtPair(sb *strings.Builder, key string, value string) {
+	if sb.Len() > 0 {
+		sb.WriteString(" ")
+	}
+	sb.WriteString(key)
+	sb.WriteString("=")
+	if value == "" || strings.ContainsAny(value, " =") || strconv.Quote(value) != `"`+value+`"` {
+		value = strconv.Quote(value)
+	}
+	sb.WriteString(value)
+}
+
+// statusf reports the status of the writer and its sessions. Status messages are kept out of the aggregated output on
+// stdout, so they don't get mixed up with structured output.
+func statusf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format, args...)
+}
@@ -0,0 +1,95 @@
+/*
+Copyright © 2020 Joseph Saylor <doug@saylorsolutions.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package specfile
+
+import (
+	"testing"
+	"time"
+)
+
+var testEvent *Event = &Event{
+	Host:     "host1",
+	Tag:      "host1",
+	Hostname: "remote-host-1",
+	File:     "/var/log/syslog",
+	Time:     time.Date(2020, 5, 1, 10, 42, 0, 0, time.UTC),
+	Line:     `GET /index.html?a=b "curl" <html>`,
+}
+
+func TestFormats(t *testing.T) {
+	tests := map[string]string{
+		FORMAT_TEXT:   "[ host1 ] GET /index.html?a=b \"curl\" <html>\n",
+		FORMAT_JSONL:  `{"time":"2020-05-01T10:42:00Z","host":"host1","hostname":"remote-host-1","file":"/var/log/syslog","line":"GET /index.html?a=b \"curl\" <html>"}` + "\n",
+		FORMAT_LOGFMT: `time=2020-05-01T10:42:00Z host=host1 hostname=remote-host-1 file=/var/log/syslog line="GET /index.html?a=b \"curl\" <html>"` + "\n",
+	}
+	for format, want := range tests {
+		f, err := NewFormatter(format)
+		if err != nil {
+			t.Fatalf("Failed to create %s formatter: %v", format, err)
+		}
+		if got := f.Format(testEvent); got != want {
+			t.Errorf("Format %s got:\n%s\nWanted:\n%s", format, got, want)
+		}
+	}
+}
+
+func TestUnknownFormat(t *testing.T) {
+	if _, err := NewFormatter("xml"); err == nil {
+		t.Error("Unknown format should return an error")
+	}
+}
+
+func TestFormatsWithLevel(t *testing.T) {
+	e := *testEvent
+	e.Line = "ERROR boom"
+	e.Level = LEVEL_ERROR
+	tests := map[string]string{
+		FORMAT_JSONL:  `{"time":"2020-05-01T10:42:00Z","host":"host1","hostname":"remote-host-1","file":"/var/log/syslog","level":"error","line":"ERROR boom"}` + "\n",
+		FORMAT_LOGFMT: `time=2020-05-01T10:42:00Z host=host1 hostname=remote-host-1 file=/var/log/syslog level=error line="ERROR boom"` + "\n",
+	}
+	for format, want := range tests {
+		f, _ := NewFormatter(format)
+		if got := f.Format(&e); got != want {
+			t.Errorf("Format %s got:\n%s\nWanted:\n%s", format, got, want)
+		}
+	}
+}
+
+func TestBackfillText(t *testing.T) {
+	e := *testEvent
+	e.Line = "old line"
+	e.Backfill = true
+	if got, want := (textFormatter{}).Format(&e), "[ host1 ~] old line\n"; got != want {
+		t.Errorf("Got:\n%q\nWanted:\n%q", got, want)
+	}
+}
+
+func TestStderrFormats(t *testing.T) {
+	e := *testEvent
+	e.Line = "tail: /var/log/syslog: file truncated"
+	e.Stream = STREAM_STDERR
+	tests := map[string]string{
+		FORMAT_TEXT:   "[ host1 !] tail: /var/log/syslog: file truncated\n",
+		FORMAT_JSONL:  `{"time":"2020-05-01T10:42:00Z","host":"host1","hostname":"remote-host-1","file":"/var/log/syslog","stream":"stderr","line":"tail: /var/log/syslog: file truncated"}` + "\n",
+		FORMAT_LOGFMT: `time=2020-05-01T10:42:00Z host=host1 hostname=remote-host-1 file=/var/log/syslog stream=stderr line="tail: /var/log/syslog: file truncated"` + "\n",
+	}
+	for format, want := range tests {
+		f, _ := NewFormatter(format)
+		if got := f.Format(&e); got != want {
+			t.Errorf("Format %s got:\n%s\nWanted:\n%s", format, got, want)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+/*
+Copyright © 2020 Joseph Saylor <doug@saylorsolutions.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package specfile
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// NewFilter creates a filter from include and exclude patterns, like those given on the command line.
+func NewFilter(include []string, exclude []string) (*FilterSpec, error) {
+	f := &FilterSpec{Include: include, Exclude: exclude}
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Empty reports whether the filter lets every line through.
+func (f *FilterSpec) Empty() bool {
+	return f == nil || (len(f.include) == 0 && len(f.exclude) == 0)
+}
+
+// Match reports whether the line should be kept.
+func (f *FilterSpec) Match(line string) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.include) > 0 {
+		included := false
+		for _, re := range f.include {
+			if re.MatchString(line) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+	for _, re := range f.exclude {
+		if re.MatchString(line) {
+			return false
+		}
+	}
+	return true
+}
+
+// grepPipeline creates the grep commands that apply the filter to the output of a remote command. Patterns are passed
+// to grep as extended regular expressions, and output is line buffered so matching lines aren't held up on the host.
+func (f *FilterSpec) grepPipeline() string {
+	if f.Empty() {
+		return ""
+	}
+	var sb strings.Builder
+	grep := func(invert bool, patterns []string) {
+		sb.WriteString(" | grep --line-buffered")
+		if invert {
+			sb.WriteString(" -v")
+		}
+		sb.WriteString(" -E")
+		for _, p := range patterns {
+			sb.WriteString(" -e ")
+			sb.WriteString(shellQuote(p))
+		}
+	}
+	if len(f.Include) > 0 {
+		grep(false, f.Include)
+	}
+	if len(f.Exclude) > 0 {
+		grep(true, f.Exclude)
+	}
+	return sb.String()
+}
+
+// filterCommand pipes the output of a remote command through the filter's grep commands. The status of a pipeline is
+// grep's rather than the command's, and not every remote shell has pipefail, so the command's status is passed around
+// grep on another descriptor and the whole thing exits with it. That way a command that fails is still reported.
+func (f *FilterSpec) filterCommand(command string) string {
+	grep := f.grepPipeline()
+	if grep == "" {
+		return command
+	}
+	return fmt.Sprintf(`exec 3>&1; status=$( { { ( %s ) 3>&- 4>&-; echo $? >&4; } | { %s; } >&3 3>&- 4>&-; } 4>&1 ); (exit ${status:-1})`,
+		command, strings.TrimPrefix(grep, " | "))
+}
+
+// filterStats counts the lines received from a host, and how many of them were filtered out.
+type filterStats struct {
+	received int
+	dropped  int
+}
+
+// filterLines applies the global filter, the host's own filters, and the minimum level to events, and keeps count of
+// what was dropped.
+type filterLines struct {
+	global   *FilterSpec
+	hosts    map[string]*FilterSpec
+	minLevel Level
+	stats    map[string]*filterStats
+}
+
+func newFilterLines(global *FilterSpec, hosts map[string]*FilterSpec, minLevel Level) *filterLines {
+	return &filterLines{global: global, hosts: hosts, minLevel: minLevel, stats: map[string]*filterStats{}}
+}
+
+// keep reports whether the event passes both the global filter and its host's filter, and isn't below the minimum
+// level. Events without a level aren't held to the minimum.
+func (f *filterLines) keep(e *Event) bool {
+	if e.Stream == STREAM_STDERR {
+		// Diagnostics are never filtered, they may be the only sign of why a host went quiet.
+		return true
+	}
+	host := f.hosts[e.Host]
+	if f.global.Empty() && host.Empty() && f.minLevel == LEVEL_UNKNOWN {
+		return true
+	}
+	stats, found := f.stats[e.Host]
+	if !found {
+		stats = &filterStats{}
+		f.stats[e.Host] = stats
+	}
+	stats.received++
+	if f.global.Match(e.Line) && host.Match(e.Line) && (e.Level == LEVEL_UNKNOWN || e.Level >= f.minLevel) {
+		return true
+	}
+	stats.dropped++
+	return false
+}
+
+// report writes how many lines were filtered out of each host's output.
+func (f *filterLines) report() {
+	hosts := []string{}
+	for host := range f.stats {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		stats := f.stats[host]
+		statusf("[ %s ] filtered out %d of %d lines\n", host, stats.dropped, stats.received)
+	}
+}
@@ -0,0 +1,96 @@
+/*
+Copyright © 2020 Joseph Saylor <doug@saylorsolutions.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package specfile
+
+import (
+	"testing"
+)
+
+func TestFilterMatch(t *testing.T) {
+	filter, err := NewFilter([]string{"ERROR", "WARN"}, []string{"healthcheck"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		line string
+		want bool
+	}{
+		{"ERROR failed to connect", true},
+		{"WARN slow response", true},
+		{"INFO started", false},
+		{"ERROR healthcheck failed", false},
+	}
+	for _, tt := range tests {
+		if got := filter.Match(tt.line); got != tt.want {
+			t.Errorf("'%s' Got:\n%v\nWanted:\n%v", tt.line, got, tt.want)
+		}
+	}
+
+	excludeOnly, _ := NewFilter(nil, []string{"^DEBUG"})
+	if !excludeOnly.Match("INFO started") || excludeOnly.Match("DEBUG details") {
+		t.Errorf("Lines should only be dropped when they match an exclude pattern")
+	}
+
+	if _, err := NewFilter([]string{"("}, nil); err == nil {
+		t.Errorf("Invalid pattern should not have passed validation")
+	}
+}
+
+func TestFilterLines(t *testing.T) {
+	global, _ := NewFilter(nil, []string{"healthcheck"})
+	web := &FilterSpec{Include: []string{"ERROR"}}
+	if err := web.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	lines := newFilterLines(global, map[string]*FilterSpec{"web": web, "db": {}}, LEVEL_UNKNOWN)
+
+	events := []*Event{
+		{Host: "web", Line: "ERROR boom"},
+		{Host: "web", Line: "INFO fine"},
+		{Host: "web", Line: "ERROR healthcheck"},
+		{Host: "db", Line: "INFO fine"},
+		{Host: "db", Line: "healthcheck ok"},
+		{Host: "web", Line: "tail: healthcheck.log: file truncated", Stream: STREAM_STDERR},
+	}
+	kept := []string{}
+	for _, e := range events {
+		if lines.keep(e) {
+			kept = append(kept, e.Host+" "+e.Line)
+		}
+	}
+	if len(kept) != 3 || kept[0] != "web ERROR boom" || kept[1] != "db INFO fine" || kept[2] != "web tail: healthcheck.log: file truncated" {
+		t.Errorf("Got:\n%v\nWanted:\n[web ERROR boom db INFO fine web tail: healthcheck.log: file truncated]", kept)
+	}
+	if s := lines.stats["web"]; s.received != 3 || s.dropped != 2 {
+		t.Errorf("Got:\n%+v\nWanted:\n{received:3 dropped:2}", *s)
+	}
+	if s := lines.stats["db"]; s.received != 2 || s.dropped != 1 {
+		t.Errorf("Got:\n%+v\nWanted:\n{received:2 dropped:1}", *s)
+	}
+}
+
+func TestGrepPipeline(t *testing.T) {
+	filter, _ := NewFilter([]string{"ERROR", "it's"}, []string{"-v"})
+	got := filter.grepPipeline()
+	want := ` | grep --line-buffered -E -e 'ERROR' -e 'it'\''s' | grep --line-buffered -v -E -e '-v'`
+	if got != want {
+		t.Errorf("Got:\n%s\nWanted:\n%s", got, want)
+	}
+	empty, _ := NewFilter(nil, nil)
+	if got := empty.grepPipeline(); got != "" {
+		t.Errorf("Got:\n%s\nWanted:\nno pipeline", got)
+	}
+}
@@ -0,0 +1,195 @@
+/*
+Copyright © 2020 Joseph Saylor <doug@saylorsolutions.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package specfile
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// DEFAULT_RESCAN_INTERVAL is how often glob patterns are expanded again to find new files.
+const DEFAULT_RESCAN_INTERVAL time.Duration = 30 * time.Second
+
+// isGlob reports whether the file is a glob pattern or a directory, rather than a single file.
+func isGlob(file string) bool {
+	return strings.ContainsAny(file, "*?[") || strings.HasSuffix(file, "/")
+}
+
+// globPattern returns the pattern to expand for a file entry. A directory matches all of the files in it.
+func globPattern(file string) string {
+	if strings.HasSuffix(file, "/") {
+		return file + "*"
+	}
+	return file
+}
+
+// globCommand creates a shell command that lists the regular files matching the pattern. The pattern is assigned to a
+// variable so it can be quoted safely, and field splitting is disabled so patterns with spaces expand correctly.
+func globCommand(pattern string) string {
+	return fmt.Sprintf(`IFS=; p=%s; for f in $p; do if [ -f "$f" ]; then printf '%%s\n' "$f"; fi; done`, shellQuote(pattern))
+}
+
+// expandSFTPGlob lists the regular files matching the pattern using the SFTP subsystem.
+func expandSFTPGlob(client *sftp.Client, pattern string) ([]string, error) {
+	matches, err := client.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{}
+	for _, m := range matches {
+		info, err := client.Stat(m)
+		if err == nil && info.Mode().IsRegular() {
+			files = append(files, m)
+		}
+	}
+	return files, nil
+}
+
+// liveClient returns the pair's client, reconnecting first if the connection was lost. None of the sessions for the
+// files matching a pattern may be around to notice that it was.
+func liveClient(pair *ClientFilePair) (*ssh.Client, error) {
+	client := pair.host.Client()
+	if probe(client, PROBE_TIMEOUT) != nil {
+		return pair.host.redial(client)
+	}
+	return client, nil
+}
+
+// outputLines splits the output of a remote command into its non-blank lines.
+func outputLines(out []byte) []string {
+	lines := []string{}
+	for _, l := range strings.Split(string(out), "\n") {
+		if l != "" {
+			lines = append(lines, l)
+		}
+	}
+	return lines
+}
+
+// expandGlob lists the files on the remote host that match the pair's pattern.
+func expandGlob(pair *ClientFilePair) ([]string, error) {
+	pattern := globPattern(pair.File)
+	client, err := liveClient(pair)
+	if err != nil {
+		return nil, err
+	}
+	if pair.Mode == MODE_SFTP {
+		client, err := sftp.NewClient(client)
+		if err != nil {
+			return nil, fmt.Errorf("Error establishing SFTP session: %v", err)
+		}
+		defer client.Close()
+		return expandSFTPGlob(client, pattern)
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("Error establishing session: %v", err)
+	}
+	defer session.Close()
+	out, err := session.Output(globCommand(pattern))
+	if err != nil {
+		return nil, err
+	}
+	return outputLines(out), nil
+}
+
+// watchGlob periodically expands the pair's pattern and starts a tail session for each new file. Files found after the
+// initial expansion are followed from the beginning, so nothing written before they were found is missed. Sessions for
+// files that no longer match are closed and forgotten, so a file that's created again is picked up again. Pairs for
+// container labels are watched the same way, with each running container that has the labels taking the place of a
+// file. Containers named in the spec aren't followed twice.
+func (c *ConsolidatedWriter) watchGlob(pattern *ClientFilePair) {
+	defer c.wg.Done()
+	interval := pattern.Spec.Rescan
+	if interval <= 0 {
+		interval = DEFAULT_RESCAN_INTERVAL
+	}
+	known := map[string]*TailSession{}
+	named := map[string]bool{}
+	expand := func(pair *ClientFilePair) ([]string, []string, error) {
+		files, err := expandGlob(pair)
+		return files, nil, err
+	}
+	if pattern.Spec.Source == SOURCE_DOCKER {
+		expand = listContainers
+		for _, name := range pattern.Spec.Docker.Container {
+			named[name] = true
+		}
+	}
+	initial := true
+	for {
+		files, stopped, err := expand(pattern)
+		if err != nil {
+			statusf("[ERROR] Failed to find %s on %s: %v\n", pattern.origin(), pattern.HostTag, err)
+		} else {
+			if !c.scan(pattern, known, named, files, stopped, !initial) {
+				return
+			}
+			initial = false
+		}
+
+		select {
+		case <-c.done:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// scan starts a tail session for each of the files that isn't known yet, and forgets those that are gone. Stopped
+// containers aren't gone: their sessions follow them again once they're restarted, from where they left off, so
+// their output isn't written twice. A stopped container that isn't known yet is picked up once it's running. It
+// returns false if the writer was closed.
+func (c *ConsolidatedWriter) scan(pattern *ClientFilePair, known map[string]*TailSession, named map[string]bool, files []string, stopped []string, fromStart bool) bool {
+	found := map[string]bool{}
+	for _, f := range stopped {
+		found[f] = true
+	}
+	for _, f := range files {
+		found[f] = true
+		if named[f] || known[f] != nil {
+			continue
+		}
+		pair := pattern.match(f, fromStart)
+		ts, _ := NewTailSession(pair)
+		known[f] = ts
+		if err := c.addSession(ts); err != nil {
+			select {
+			case <-c.done:
+				return false
+			default:
+			}
+			statusf("[ERROR] Failed to start tailing '%s' on %s: %v\n", f, pattern.HostTag, err)
+		}
+	}
+	c.forgetGone(known, found)
+	return true
+}
+
+// forgetGone closes the sessions for the known files that weren't found, and forgets them.
+func (c *ConsolidatedWriter) forgetGone(known map[string]*TailSession, found map[string]bool) {
+	for f, ts := range known {
+		if !found[f] {
+			c.removeSession(ts)
+			delete(known, f)
+		}
+	}
+}
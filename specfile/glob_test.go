@@ -0,0 +1,145 @@
+/*
+Copyright © 2020 Joseph Saylor <doug@saylorsolutions.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package specfile
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func createGlobTestDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "sshtail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.log", "b.log", "it's spaced.log", "c.txt"} {
+		appendFile(t, filepath.Join(dir, name), "")
+	}
+	if err = os.Mkdir(filepath.Join(dir, "dir.log"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestIsGlob(t *testing.T) {
+	globs := []string{"/var/log/*.log", "/var/log/app?.log", "/var/log/app[12].log", "/var/log/app/"}
+	for _, g := range globs {
+		if !isGlob(g) {
+			t.Errorf("'%s' should be a glob", g)
+		}
+	}
+	if isGlob("/var/log/syslog") {
+		t.Error("A plain file should not be a glob")
+	}
+	if got := globPattern("/var/log/app/"); got != "/var/log/app/*" {
+		t.Errorf("Directory should match all files in it, got '%s'", got)
+	}
+}
+
+func TestGlobCommand(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("No shell available to run the glob command")
+	}
+	dir := createGlobTestDir(t)
+	defer os.RemoveAll(dir)
+
+	out, err := exec.Command("sh", "-c", globCommand(filepath.Join(dir, "*.log"))).Output()
+	if err != nil {
+		t.Fatalf("Failed to run glob command: %v", err)
+	}
+	got := strings.Split(strings.TrimSpace(string(out)), "\n")
+	sort.Strings(got)
+	want := []string{filepath.Join(dir, "a.log"), filepath.Join(dir, "b.log"), filepath.Join(dir, "it's spaced.log")}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Got:\n%v\nWanted:\n%v", got, want)
+	}
+
+	out, err = exec.Command("sh", "-c", globCommand(filepath.Join(dir, "*.none"))).Output()
+	if err != nil {
+		t.Fatalf("Glob command should not fail without matches: %v", err)
+	}
+	if len(out) > 0 {
+		t.Errorf("Expected no matches, got %q", out)
+	}
+}
+
+func TestExpandSFTPGlob(t *testing.T) {
+	dir := createGlobTestDir(t)
+	defer os.RemoveAll(dir)
+
+	got, err := expandSFTPGlob(newTestSFTPClient(t), globPattern(dir+"/"))
+	if err != nil {
+		t.Fatalf("Failed to expand glob: %v", err)
+	}
+	sort.Strings(got)
+	want := []string{filepath.Join(dir, "a.log"), filepath.Join(dir, "b.log"), filepath.Join(dir, "c.txt"), filepath.Join(dir, "it's spaced.log")}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Got:\n%v\nWanted:\n%v", got, want)
+	}
+}
+
+func TestForgetGone(t *testing.T) {
+	c := &ConsolidatedWriter{}
+	known := map[string]*TailSession{}
+	for _, f := range []string{"/var/log/app-1.log", "/var/log/app-2.log"} {
+		ts, _ := NewTailSession(&ClientFilePair{HostTag: "host1", File: f, Tag: "host1:" + f})
+		known[f] = ts
+		c.sessions = append(c.sessions, ts)
+	}
+	gone := known["/var/log/app-1.log"]
+
+	c.forgetGone(known, map[string]bool{"/var/log/app-2.log": true})
+	if _, found := known["/var/log/app-1.log"]; found || len(known) != 1 {
+		t.Errorf("Got:\n%v\nWanted:\nonly /var/log/app-2.log", known)
+	}
+	for _, ts := range c.sessions {
+		if ts == gone {
+			t.Error("Session for a file that's gone should no longer be tracked")
+		}
+	}
+	if len(c.sessions) != 1 {
+		t.Errorf("Got:\n%d sessions\nWanted:\n1", len(c.sessions))
+	}
+}
+
+func TestScanStoppedContainer(t *testing.T) {
+	c := &ConsolidatedWriter{done: make(chan struct{})}
+	pattern := &ClientFilePair{HostTag: "host1", Tag: "host1", Spec: &HostSpec{Source: SOURCE_DOCKER, Docker: DockerSpec{Label: StringList{"app=web"}}}}
+	ts, _ := NewTailSession(pattern.match("web-1", false))
+	known := map[string]*TailSession{"web-1": ts}
+	c.sessions = append(c.sessions, ts)
+
+	// The container stops, then is started again.
+	for _, scan := range []struct{ running, stopped []string }{{nil, []string{"web-1"}}, {[]string{"web-1"}, nil}} {
+		if !c.scan(pattern, known, map[string]bool{}, scan.running, scan.stopped, true) {
+			t.Fatal("Scan should only stop once the writer is closed")
+		}
+		if known["web-1"] != ts || len(c.sessions) != 1 {
+			t.Errorf("Got:\n%v\nWanted the session for web-1 to be kept", known)
+		}
+	}
+
+	// The container is removed.
+	c.scan(pattern, known, map[string]bool{}, nil, nil, true)
+	if len(known) != 0 || len(c.sessions) != 0 {
+		t.Errorf("Got:\n%v\nWanted the session for web-1 to be forgotten", known)
+	}
+}
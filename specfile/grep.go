@@ -0,0 +1,256 @@
+/*
+Copyright © 2020 Joseph Saylor <doug@saylorsolutions.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package specfile
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// GrepOptions controls a search of the files in a spec.
+type GrepOptions struct {
+	// Pattern is an extended regular expression. It's run by grep on hosts in tail mode, and by Go's regexp package on
+	// hosts in sftp mode.
+	Pattern    string
+	IgnoreCase bool
+	// Rotated includes rotated copies of each file, like syslog.1 or syslog-20200501.gz, in the search.
+	Rotated bool
+}
+
+// searchPatterns returns the glob patterns of the files to search for a file entry.
+func searchPatterns(file string, rotated bool) []string {
+	pattern := globPattern(file)
+	patterns := []string{pattern}
+	if rotated && !strings.HasSuffix(file, "/") {
+		patterns = append(patterns, pattern+".[0-9]*", pattern+"-[0-9]*")
+	}
+	return patterns
+}
+
+// grepCommand creates a shell command that searches each file matching the patterns. The marker and the file's name
+// are written before each file's matches. Compressed files are decompressed with gzip. The command fails if grep
+// fails for any file, but not if a file has no matches.
+func grepCommand(patterns []string, search *GrepOptions, marker string) string {
+	quoted := []string{}
+	for _, p := range patterns {
+		quoted = append(quoted, shellQuote(p))
+	}
+	flags := "-E"
+	if search.IgnoreCase {
+		flags = "-i -E"
+	}
+	grep := fmt.Sprintf("grep %s -e %s", flags, shellQuote(search.Pattern))
+	return fmt.Sprintf(`IFS=; rc=0; for p in %s; do for f in $p; do if [ -f "$f" ]; then printf '%%s%%s\n' %s "$f"; `+
+		`case "$f" in *.gz) gzip -dc -- "$f" | %s;; *) %s -- "$f";; esac; [ $? -gt 1 ] && rc=2; fi; done; done; exit $rc`,
+		strings.Join(quoted, " "), shellQuote(marker), grep, grep)
+}
+
+// grepHost searches all of the host's files, sending each match to the channel.
+func grepHost(pairs []*ClientFilePair, search *GrepOptions, re *regexp.Regexp, ch chan<- *Event) error {
+	host := pairs[0]
+	patterns := []string{}
+	seen := map[string]bool{}
+	for _, pair := range pairs {
+		for _, p := range searchPatterns(pair.File, search.Rotated) {
+			if !seen[p] {
+				seen[p] = true
+				patterns = append(patterns, p)
+			}
+		}
+	}
+	if host.Mode == MODE_SFTP {
+		return grepSFTP(host, patterns, re, ch)
+	}
+
+	session, err := host.host.Client().NewSession()
+	if err != nil {
+		return fmt.Errorf("Error establishing session: %v", err)
+	}
+	defer session.Close()
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	marker := newBackfillMarker()
+	if err = session.Start(grepCommand(patterns, search, marker)); err != nil {
+		return err
+	}
+	r := bufio.NewReader(stdout)
+	file := ""
+	for {
+		line, err := r.ReadString('\n')
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+		if strings.HasPrefix(line, marker) {
+			file = strings.TrimPrefix(line, marker)
+		} else if line != "" || err == nil {
+			ch <- matchEvent(host, file, line)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err = session.Wait(); err != nil {
+		return fmt.Errorf("Search failed: %v", err)
+	}
+	return nil
+}
+
+// grepSFTP searches the files matching the patterns by reading them over SFTP, since there may not be a shell to run
+// grep with.
+func grepSFTP(host *ClientFilePair, patterns []string, re *regexp.Regexp, ch chan<- *Event) error {
+	client, err := sftp.NewClient(host.host.Client())
+	if err != nil {
+		return fmt.Errorf("Error establishing SFTP session: %v", err)
+	}
+	defer client.Close()
+	for _, p := range patterns {
+		files, err := expandSFTPGlob(client, p)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			if err := grepSFTPFile(client, host, file, re, ch); err != nil {
+				return fmt.Errorf("Failed to search '%s': %v", file, err)
+			}
+		}
+	}
+	return nil
+}
+
+func grepSFTPFile(client *sftp.Client, host *ClientFilePair, file string, re *regexp.Regexp, ch chan<- *Event) error {
+	f, err := client.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(file, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+		if (line != "" || err == nil) && re.MatchString(line) {
+			ch <- matchEvent(host, file, line)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func matchEvent(host *ClientFilePair, file string, line string) *Event {
+	return &Event{
+		Host:     host.HostTag,
+		Tag:      fileTag(host.HostTag, file, true),
+		Hostname: host.Spec.Hostname,
+		File:     file,
+		Time:     time.Now(),
+		Line:     line,
+	}
+}
+
+// Grep searches the files of every host in the spec in parallel, writing matches as they're found. Glob patterns and
+// directories are expanded on each host. An error is returned once all hosts are done if the search failed on any of
+// them. Hosts that don't follow files, like journal hosts, are skipped.
+func Grep(specData *SpecData, opts *ClientOptions, search *GrepOptions, formatter Formatter, out io.Writer) error {
+	expr := search.Pattern
+	if search.IgnoreCase {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return fmt.Errorf("Invalid pattern: %v", err)
+	}
+	clientPairs, jumps, err := setupClients(specData, opts, false)
+	if err != nil {
+		return err
+	}
+	defer jumps.Close()
+
+	hosts := map[string][]*ClientFilePair{}
+	skipped := map[string]bool{}
+	for _, pair := range clientPairs {
+		if !pair.Spec.followsFiles() {
+			if !skipped[pair.HostTag] {
+				skipped[pair.HostTag] = true
+				statusf("[ %s ] skipped, only files can be searched\n", pair.HostTag)
+				pair.host.Close()
+			}
+			continue
+		}
+		hosts[pair.HostTag] = append(hosts[pair.HostTag], pair)
+	}
+	ch := make(chan *Event, len(hosts))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for e := range ch {
+			io.WriteString(out, formatter.Format(e))
+		}
+	}()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failed := []string{}
+	for tag, pairs := range hosts {
+		wg.Add(1)
+		go func(tag string, pairs []*ClientFilePair) {
+			defer wg.Done()
+			defer pairs[0].host.Close()
+			err := errNotConnected
+			if pairs[0].host.Client() != nil {
+				err = grepHost(pairs, search, re, ch)
+			}
+			if err != nil {
+				statusf("[ %s ] %v\n", tag, err)
+				mu.Lock()
+				failed = append(failed, tag)
+				mu.Unlock()
+			}
+		}(tag, pairs)
+	}
+	wg.Wait()
+	close(ch)
+	<-done
+
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return fmt.Errorf("Search failed on %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
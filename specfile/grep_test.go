@@ -0,0 +1,107 @@
+/*
+Copyright © 2020 Joseph Saylor <doug@saylorsolutions.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package specfile
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+// writeRotatedLogs creates a log file with a rotated copy and a compressed rotated copy.
+func writeRotatedLogs(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "sshtail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(dir, "app.log")
+	appendFile(t, name, "INFO current\nERROR current\n")
+	appendFile(t, name+".1", "ERROR rotated\nINFO rotated\n")
+	f, err := os.Create(name + ".2.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	gz.Write([]byte("error compressed\n"))
+	gz.Close()
+	f.Close()
+	return dir
+}
+
+func TestSearchPatterns(t *testing.T) {
+	got := searchPatterns("/var/log/syslog", true)
+	want := []string{"/var/log/syslog", "/var/log/syslog.[0-9]*", "/var/log/syslog-[0-9]*"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got:\n%v\nWanted:\n%v", got, want)
+	}
+	if got := searchPatterns("/var/log/app/", true); !reflect.DeepEqual(got, []string{"/var/log/app/*"}) {
+		t.Errorf("Got:\n%v\nWanted:\n[/var/log/app/*]", got)
+	}
+}
+
+func TestGrepCommand(t *testing.T) {
+	dir := writeRotatedLogs(t)
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "app.log")
+
+	cmd := grepCommand(searchPatterns(name, true), &GrepOptions{Pattern: "error", IgnoreCase: true}, "@@")
+	out, err := exec.Command("sh", "-c", cmd).Output()
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	want := "@@" + name + "\nERROR current\n" + "@@" + name + ".1\nERROR rotated\n" + "@@" + name + ".2.gz\nerror compressed\n"
+	if string(out) != want {
+		t.Errorf("Got:\n%s\nWanted:\n%s", out, want)
+	}
+
+	cmd = grepCommand([]string{filepath.Join(dir, "missing.log")}, &GrepOptions{Pattern: "("}, "@@")
+	if err := exec.Command("sh", "-c", cmd).Run(); err != nil {
+		t.Errorf("Missing files should be skipped, got: %v", err)
+	}
+	cmd = grepCommand([]string{name}, &GrepOptions{Pattern: "("}, "@@")
+	if err := exec.Command("sh", "-c", cmd).Run(); err == nil {
+		t.Errorf("Invalid pattern should fail the search")
+	}
+}
+
+func TestGrepSFTPFile(t *testing.T) {
+	dir := writeRotatedLogs(t)
+	defer os.RemoveAll(dir)
+	client := newTestSFTPClient(t)
+	host := &ClientFilePair{HostTag: "host1", Spec: &HostSpec{Hostname: "remote-host-1"}}
+	re := regexp.MustCompile("(?i)error")
+
+	ch := make(chan *Event, 10)
+	for _, file := range []string{"app.log", "app.log.1", "app.log.2.gz"} {
+		if err := grepSFTPFile(client, host, filepath.Join(dir, file), re, ch); err != nil {
+			t.Fatalf("Failed to search '%s': %v", file, err)
+		}
+	}
+	close(ch)
+	got := []string{}
+	for e := range ch {
+		got = append(got, filepath.Base(e.File)+" "+e.Line)
+	}
+	want := []string{"app.log ERROR current", "app.log.1 ERROR rotated", "app.log.2.gz error compressed"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got:\n%v\nWanted:\n%v", got, want)
+	}
+}
@@ -0,0 +1,195 @@
+/*
+Copyright © 2020 Joseph Saylor <doug@saylorsolutions.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package specfile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// journalPriorities are the priority names journalctl accepts, from 0 to 7.
+var journalPriorities = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// journalFields maps the journal fields kept with each entry to the names they're given in structured output.
+var journalFields = map[string]string{
+	"_SYSTEMD_UNIT":     "unit",
+	"SYSLOG_IDENTIFIER": "identifier",
+	"_PID":              "pid",
+}
+
+// JournalSpec selects the systemd journal entries followed for a host. If Unit is given, then only entries from those
+// units are followed. Priority is a priority name or number, or a range like 'err..warning', and Match entries are
+// journalctl matches like '_COMM=sshd', with '+' between groups of matches that are alternatives.
+type JournalSpec struct {
+	Unit     StringList `json:"unit" yaml:"unit"`
+	Priority string     `json:"priority" yaml:"priority"`
+	Match    []string   `json:"match" yaml:"match"`
+}
+
+// Validate checks the JournalSpec for errors.
+func (j *JournalSpec) Validate() error {
+	for _, u := range j.Unit {
+		if u == "" {
+			return errors.New("Journal spec cannot have a blank unit")
+		}
+	}
+	if j.Priority != "" {
+		for _, p := range strings.SplitN(j.Priority, "..", 2) {
+			if !validPriority(p) {
+				return fmt.Errorf("Unknown priority '%s', must be one of %s or 0-7", j.Priority, strings.Join(journalPriorities, ", "))
+			}
+		}
+	}
+	for _, m := range j.Match {
+		if m != "+" && strings.IndexByte(m, '=') <= 0 {
+			return fmt.Errorf("Match '%s' must be FIELD=value or '+'", m)
+		}
+	}
+	return nil
+}
+
+func validPriority(p string) bool {
+	for i, name := range journalPriorities {
+		if p == name || p == strconv.Itoa(i) {
+			return true
+		}
+	}
+	return false
+}
+
+// args returns the journalctl arguments that select the spec's entries as JSON.
+func (j *JournalSpec) args() string {
+	args := []string{"-o", "json"}
+	for _, u := range j.Unit {
+		args = append(args, "-u", shellQuote(u))
+	}
+	if j.Priority != "" {
+		args = append(args, "-p", shellQuote(j.Priority))
+	}
+	for _, m := range j.Match {
+		args = append(args, shellQuote(m))
+	}
+	return strings.Join(args, " ")
+}
+
+// journalFollower uses the remote 'journalctl' executable to follow the journal.
+type journalFollower struct {
+	session  *ssh.Session
+	journal  *JournalSpec
+	backfill int
+	marker   string
+	since    time.Time
+}
+
+func (j *journalFollower) follow(out io.Writer, errOut io.Writer) error {
+	j.session.Stdout = out
+	j.session.Stderr = errOut
+	return j.session.Run(j.command())
+}
+
+// command creates the remote command that follows the journal. To backfill, the last entries, or the entries logged
+// since the start time, are written along with the cursor of the last one. Then the marker is written, and the journal
+// is followed from after the cursor, so no entry is missed or written twice.
+func (j *journalFollower) command() string {
+	args := j.journal.args()
+	if j.marker == "" {
+		return "journalctl -f -n 0 " + args
+	}
+	history := fmt.Sprintf("-n %d", j.backfill)
+	if !j.since.IsZero() {
+		history = fmt.Sprintf("--since=@%d", j.since.Unix())
+	}
+	return fmt.Sprintf(`journalctl -q --no-pager --show-cursor %[1]s %[2]s | { c=; while IFS= read -r l; do `+
+		`case "$l" in '-- cursor: '*) c=${l#'-- cursor: '};; *) printf '%%s\n' "$l";; esac; done; printf '%%s\n' %[3]s; `+
+		`if [ -n "$c" ]; then exec journalctl -f -n all --after-cursor="$c" %[2]s; else exec journalctl -f -n 0 %[2]s; fi; }`,
+		history, args, shellQuote(j.marker))
+}
+
+func (j *journalFollower) Close() error {
+	return j.session.Close()
+}
+
+// decodeJournal fills in the event from the journal entry in its line. The entry's message becomes the line, and its
+// timestamp, priority and unit are kept. If multiple is set, then the unit, or the syslog identifier for entries
+// without one, is added to the tag. Lines that aren't journal entries are left as they are.
+func decodeJournal(e *Event, multiple bool) {
+	entry := map[string]json.RawMessage{}
+	if err := json.Unmarshal([]byte(e.Line), &entry); err != nil {
+		return
+	}
+	message, ok := journalField(entry["MESSAGE"])
+	if !ok {
+		return
+	}
+	e.Line = message
+	if ts, ok := journalField(entry["__REALTIME_TIMESTAMP"]); ok {
+		if us, err := strconv.ParseInt(ts, 10, 64); err == nil {
+			e.Logged = time.Unix(0, us*int64(time.Microsecond))
+		}
+	}
+	if p, ok := journalField(entry["PRIORITY"]); ok {
+		if n, err := strconv.Atoi(p); err == nil && n >= 0 && n < len(syslogLevels) {
+			e.Level = syslogLevels[n]
+		}
+	}
+	e.Fields = map[string]string{}
+	for key, name := range journalFields {
+		if v, ok := journalField(entry[key]); ok && v != "" {
+			e.Fields[name] = v
+		}
+	}
+	if multiple {
+		source := e.Fields["unit"]
+		if source == "" {
+			source = e.Fields["identifier"]
+		}
+		if source != "" {
+			e.Tag = fmt.Sprintf("%s:%s", e.Tag, source)
+		}
+	}
+}
+
+// journalField decodes a field of a journal entry. Values that aren't valid UTF-8 are written by journalctl as arrays
+// of bytes, and fields that appear more than once as arrays of values, of which the first is used.
+func journalField(raw json.RawMessage) (string, bool) {
+	if len(raw) == 0 {
+		return "", false
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s, true
+	}
+	var bytes []int
+	if json.Unmarshal(raw, &bytes) == nil {
+		b := make([]byte, len(bytes))
+		for i, c := range bytes {
+			b[i] = byte(c)
+		}
+		return string(b), true
+	}
+	var values []json.RawMessage
+	if json.Unmarshal(raw, &values) == nil && len(values) > 0 {
+		return journalField(values[0])
+	}
+	return "", false
+}
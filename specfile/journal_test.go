@@ -0,0 +1,110 @@
+/*
+Copyright © 2020 Joseph Saylor <doug@saylorsolutions.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package specfile
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestJournalSpec(t *testing.T) {
+	spec := &HostSpec{}
+	err := yaml.Unmarshal([]byte("hostname: remote-host-1\nsource: journal\njournal:\n  unit: api.service\n  priority: warning\n  match: [_COMM=api]\n"), spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := spec.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	want := JournalSpec{Unit: StringList{"api.service"}, Priority: "warning", Match: []string{"_COMM=api"}}
+	if !reflect.DeepEqual(spec.Journal, want) {
+		t.Errorf("Got:\n%v\nWanted:\n%v", spec.Journal, want)
+	}
+
+	for _, invalid := range []*HostSpec{
+		{Hostname: "remote-host-1", Source: SOURCE_JOURNAL, File: "/var/log/syslog"},
+		{Hostname: "remote-host-1", Source: SOURCE_JOURNAL, Mode: MODE_SFTP},
+		{Hostname: "remote-host-1", Source: SOURCE_JOURNAL, Journal: JournalSpec{Priority: "loud"}},
+		{Hostname: "remote-host-1", Source: SOURCE_JOURNAL, Journal: JournalSpec{Priority: "err..loud"}},
+		{Hostname: "remote-host-1", Source: SOURCE_JOURNAL, Journal: JournalSpec{Match: []string{"sshd"}}},
+		{Hostname: "remote-host-1", Source: "socket", File: "/var/log/syslog"},
+	} {
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("Invalid host spec %+v should be an error", invalid)
+		}
+	}
+}
+
+func TestJournalCommand(t *testing.T) {
+	journal := &JournalSpec{Unit: StringList{"api.service"}, Priority: "err..warning", Match: []string{"_COMM=api", "+", "_PID=1"}}
+	f := &journalFollower{journal: journal}
+	want := "journalctl -f -n 0 -o json -u 'api.service' -p 'err..warning' '_COMM=api' '+' '_PID=1'"
+	if got := f.command(); got != want {
+		t.Errorf("Got:\n%s\nWanted:\n%s", got, want)
+	}
+
+	f = &journalFollower{journal: &JournalSpec{}, backfill: 10, marker: "marker"}
+	got := f.command()
+	for _, want := range []string{"journalctl -q --no-pager --show-cursor -n 10 -o json |", "printf '%s\\n' 'marker'", `--after-cursor="$c" -o json`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Got:\n%s\nWanted it to contain:\n%s", got, want)
+		}
+	}
+	f.since = time.Unix(1588334400, 0)
+	if got := f.command(); !strings.Contains(got, "--show-cursor --since=@1588334400 -o json") {
+		t.Errorf("Got:\n%s\nWanted a start time", got)
+	}
+}
+
+func TestDecodeJournal(t *testing.T) {
+	e := &Event{Tag: "host1", Line: `{"__REALTIME_TIMESTAMP":"1588334400123456","PRIORITY":"3","_SYSTEMD_UNIT":"api.service",` +
+		`"SYSLOG_IDENTIFIER":["api","api2"],"_PID":"42","MESSAGE":[104,105,255]}`}
+	decodeJournal(e, true)
+	want := &Event{
+		Tag:    "host1:api.service",
+		Logged: time.Unix(1588334400, 123456000),
+		Line:   "hi\xff",
+		Level:  LEVEL_ERROR,
+		Fields: map[string]string{"unit": "api.service", "identifier": "api", "pid": "42"},
+	}
+	if !reflect.DeepEqual(e, want) {
+		t.Errorf("Got:\n%+v\nWanted:\n%+v", e, want)
+	}
+
+	e = &Event{Tag: "host1", Line: "Failed to add match '_COMM': Invalid argument"}
+	decodeJournal(e, true)
+	if e.Line != "Failed to add match '_COMM': Invalid argument" || e.Tag != "host1" {
+		t.Errorf("Got:\n%+v\nWanted the line unchanged", e)
+	}
+}
+
+func TestWriterJournal(t *testing.T) {
+	spec := &HostSpec{Hostname: "remote-host-1", Source: SOURCE_JOURNAL, Journal: JournalSpec{Unit: StringList{"api.service"}}, RemoteFilters: FilterSpec{Exclude: []string{"DEBUG"}}}
+	if err := spec.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	pair := &ClientFilePair{HostTag: "host1", Mode: spec.Mode, Tag: "host1", Spec: spec}
+	ch := make(chan *Event, 10)
+	w := NewTailChannelWriter(pair, ch)
+	defer w.Flush()
+
+	w.Write([]byte(`{"MESSAGE":"INFO kept"}` + "\n" + `{"MESSAGE":"DEBUG dropped"}` + "\n"))
+	expectLines(t, ch, "[ host1 ] INFO kept\n")
+}
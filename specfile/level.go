@@ -0,0 +1,247 @@
+/*
+Copyright © 2020 Joseph Saylor <doug@saylorsolutions.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package specfile
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Level is the severity of a log line. Lines without a recognizable level are LEVEL_UNKNOWN.
+type Level int
+
+// Log levels, from least to most severe.
+const (
+	LEVEL_UNKNOWN Level = iota
+	LEVEL_TRACE
+	LEVEL_DEBUG
+	LEVEL_INFO
+	LEVEL_NOTICE
+	LEVEL_WARN
+	LEVEL_ERROR
+	LEVEL_FATAL
+)
+
+var levelNames = []string{"", "trace", "debug", "info", "notice", "warn", "error", "fatal"}
+
+func (l Level) String() string {
+	if l < LEVEL_UNKNOWN || int(l) >= len(levelNames) {
+		return ""
+	}
+	return levelNames[l]
+}
+
+// levelAliases maps the level names used by common logging libraries and syslog to a Level.
+var levelAliases = map[string]Level{
+	"trace":       LEVEL_TRACE,
+	"debug":       LEVEL_DEBUG,
+	"dbg":         LEVEL_DEBUG,
+	"info":        LEVEL_INFO,
+	"information": LEVEL_INFO,
+	"notice":      LEVEL_NOTICE,
+	"warn":        LEVEL_WARN,
+	"warning":     LEVEL_WARN,
+	"err":         LEVEL_ERROR,
+	"error":       LEVEL_ERROR,
+	"severe":      LEVEL_ERROR,
+	"crit":        LEVEL_FATAL,
+	"critical":    LEVEL_FATAL,
+	"alert":       LEVEL_FATAL,
+	"emerg":       LEVEL_FATAL,
+	"emergency":   LEVEL_FATAL,
+	"fatal":       LEVEL_FATAL,
+	"panic":       LEVEL_FATAL,
+}
+
+// syslogLevels maps syslog priorities, from emerg at 0 to debug at 7, to a Level.
+var syslogLevels = []Level{LEVEL_FATAL, LEVEL_FATAL, LEVEL_FATAL, LEVEL_ERROR, LEVEL_WARN, LEVEL_NOTICE, LEVEL_INFO, LEVEL_DEBUG}
+
+// ParseLevel parses a level name, like one given on the command line.
+func ParseLevel(name string) (Level, error) {
+	if l, ok := parseLevelToken(name); ok {
+		return l, nil
+	}
+	return LEVEL_UNKNOWN, fmt.Errorf("Unknown log level '%s', must be one of %s", name, strings.Join(levelNames[1:], ", "))
+}
+
+// parseLevelToken parses a level name or number found in a line. Numbers up to 7 are syslog priorities, and larger
+// numbers are the levels used by bunyan and pino, from trace at 10 to fatal at 60.
+func parseLevelToken(token string) (Level, bool) {
+	if l, found := levelAliases[strings.ToLower(token)]; found {
+		return l, true
+	}
+	n, err := strconv.Atoi(token)
+	if err != nil {
+		return LEVEL_UNKNOWN, false
+	}
+	return parseLevelNumber(n)
+}
+
+func parseLevelNumber(n int) (Level, bool) {
+	switch {
+	case n < 0:
+		return LEVEL_UNKNOWN, false
+	case n < len(syslogLevels):
+		return syslogLevels[n], true
+	case n < 10:
+		return LEVEL_UNKNOWN, false
+	case n < 20:
+		return LEVEL_TRACE, true
+	case n < 30:
+		return LEVEL_DEBUG, true
+	case n < 40:
+		return LEVEL_INFO, true
+	case n < 50:
+		return LEVEL_WARN, true
+	case n < 60:
+		return LEVEL_ERROR, true
+	default:
+		return LEVEL_FATAL, true
+	}
+}
+
+// syslogPriority finds a syslog priority prefix, like the kernel's '<3>' or '<13>' from logger. It's tried before the
+// other patterns used when a host doesn't set its own.
+var syslogPriority = regexp.MustCompile(`^<(\d{1,3})>`)
+
+// parseSyslogPriority parses a syslog priority, which is the facility times 8 plus the severity.
+func parseSyslogPriority(token string) (Level, bool) {
+	n, err := strconv.Atoi(token)
+	if err != nil || n >= 24*8 {
+		return LEVEL_UNKNOWN, false
+	}
+	return syslogLevels[n%8], true
+}
+
+// Patterns used to find a level when a host doesn't set its own, in the order they're tried. The first group is the
+// level.
+var defaultLevelPatterns = []*regexp.Regexp{
+	// logfmt style fields, like 'level=info'.
+	regexp.MustCompile(`(?i)\b(?:level|lvl|severity)=["']?([a-z]+)`),
+	// Bracketed levels, like Apache and nginx's '[error]'.
+	regexp.MustCompile(`\[(?i:(trace|debug|info|notice|warn|warning|error|crit|alert|emerg))\]`),
+	// Upper case levels anywhere in the line.
+	regexp.MustCompile(`\b(TRACE|DEBUG|INFO|NOTICE|WARN|WARNING|ERROR|ERR|CRIT|CRITICAL|FATAL|EMERG|ALERT|PANIC|SEVERE)\b`),
+}
+
+// defaultLevelFields are the fields checked for a level in JSON lines when a host doesn't set its own.
+var defaultLevelFields = []string{"level", "lvl", "severity", "log.level"}
+
+// Detect finds the level of the line, and where the level is in the line so it can be highlighted. The host's own
+// pattern is tried first, then JSON fields, then patterns for common log formats.
+func (l *LevelSpec) Detect(line string) (level Level, start int, end int) {
+	if l != nil && l.pattern != nil {
+		if level, start, end = matchLevel(l.pattern, line, l.parseToken); level != LEVEL_UNKNOWN {
+			return level, start, end
+		}
+	}
+	if strings.HasPrefix(strings.TrimSpace(line), "{") {
+		fields := defaultLevelFields
+		if l != nil && l.Field != "" {
+			fields = []string{l.Field}
+		}
+		if level, start, end = jsonLevel(line, fields); level != LEVEL_UNKNOWN {
+			return level, start, end
+		}
+	}
+	if level, start, end = matchLevel(syslogPriority, line, parseSyslogPriority); level != LEVEL_UNKNOWN {
+		return level, start, end
+	}
+	for _, re := range defaultLevelPatterns {
+		if level, start, end = matchLevel(re, line, parseLevelToken); level != LEVEL_UNKNOWN {
+			return level, start, end
+		}
+	}
+	return LEVEL_UNKNOWN, 0, 0
+}
+
+// parseToken parses a level found by the host's pattern, using the host's own level names first.
+func (l *LevelSpec) parseToken(token string) (Level, bool) {
+	if level, found := l.names[token]; found {
+		return level, true
+	}
+	return parseLevelToken(token)
+}
+
+// matchLevel finds the level with the pattern, which is the first group if it has one.
+func matchLevel(re *regexp.Regexp, line string, parse func(string) (Level, bool)) (Level, int, int) {
+	m := re.FindStringSubmatchIndex(line)
+	if m == nil {
+		return LEVEL_UNKNOWN, 0, 0
+	}
+	start, end := m[0], m[1]
+	if len(m) > 2 && m[2] >= 0 {
+		start, end = m[2], m[3]
+	}
+	level, ok := parse(line[start:end])
+	if !ok {
+		return LEVEL_UNKNOWN, 0, 0
+	}
+	return level, start, end
+}
+
+// jsonLevel finds the level in the first of the fields the JSON line has.
+func jsonLevel(line string, fields []string) (Level, int, int) {
+	record := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(line), &record); err != nil {
+		return LEVEL_UNKNOWN, 0, 0
+	}
+	for _, field := range fields {
+		var level Level
+		var ok bool
+		var token string
+		switch v := record[field].(type) {
+		case string:
+			level, ok = parseLevelToken(v)
+			token = strconv.Quote(v)
+		case float64:
+			level, ok = parseLevelNumber(int(v))
+			token = strconv.FormatFloat(v, 'f', -1, 64)
+		}
+		if !ok {
+			continue
+		}
+		start := strings.Index(line, token)
+		if start < 0 {
+			return level, 0, 0
+		}
+		return level, start, start + len(token)
+	}
+	return LEVEL_UNKNOWN, 0, 0
+}
+
+// levelColors are the ANSI SGR parameters used to highlight each level.
+var levelColors = map[Level]string{
+	LEVEL_TRACE:  "2",
+	LEVEL_DEBUG:  "2",
+	LEVEL_INFO:   "32",
+	LEVEL_NOTICE: "36",
+	LEVEL_WARN:   "33",
+	LEVEL_ERROR:  "31",
+	LEVEL_FATAL:  "1;31",
+}
+
+// highlightLevel colors the event's level where it was found in the line.
+func highlightLevel(e *Event) string {
+	color, found := levelColors[e.Level]
+	if !found || e.levelEnd <= e.levelStart || e.levelEnd > len(e.Line) {
+		return e.Line
+	}
+	return e.Line[:e.levelStart] + "\x1b[" + color + "m" + e.Line[e.levelStart:e.levelEnd] + colorReset + e.Line[e.levelEnd:]
+}
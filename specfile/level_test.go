@@ -0,0 +1,103 @@
+/*
+Copyright © 2020 Joseph Saylor <doug@saylorsolutions.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package specfile
+
+import (
+	"testing"
+)
+
+func TestDetectLevel(t *testing.T) {
+	tests := []struct {
+		line  string
+		want  Level
+		token string
+	}{
+		{"2020-05-01 10:42:00 ERROR failed to connect", LEVEL_ERROR, "ERROR"},
+		{"2020-05-01 10:42:00 [WARN] slow response", LEVEL_WARN, "WARN"},
+		{`time=2020-05-01T10:42:00Z level=debug msg="cache miss"`, LEVEL_DEBUG, "debug"},
+		{"[Fri May 01 10:42:00 2020] [error] [client 127.0.0.1] File does not exist", LEVEL_ERROR, "error"},
+		{"<4>usb 1-1: device descriptor read error", LEVEL_WARN, "4"},
+		{"<13>May  1 10:42:00 host1 joe: backup started", LEVEL_NOTICE, "13"},
+		{"<11>May  1 10:42:00 host1 cron[42]: job failed", LEVEL_ERROR, "11"},
+		{"<86>May  1 10:42:00 host1 sshd[42]: session opened", LEVEL_INFO, "86"},
+		{`{"level":"info","msg":"started"}`, LEVEL_INFO, `"info"`},
+		{`{"level":50,"msg":"bunyan error"}`, LEVEL_ERROR, "50"},
+		{"Connection closed by an error on the server", LEVEL_UNKNOWN, ""},
+	}
+	for _, tt := range tests {
+		level, start, end := (*LevelSpec)(nil).Detect(tt.line)
+		if level != tt.want || tt.line[start:end] != tt.token {
+			t.Errorf("'%s' Got:\n%v %q\nWanted:\n%v %q", tt.line, level, tt.line[start:end], tt.want, tt.token)
+		}
+	}
+}
+
+func TestDetectHostLevel(t *testing.T) {
+	spec := &LevelSpec{Pattern: `^\S+ (\w)/`, Field: "sev", Names: map[string]string{"E": "error", "W": "warn"}}
+	if err := spec.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if level, _, _ := spec.Detect("10:42:00 E/ActivityManager: crashed"); level != LEVEL_ERROR {
+		t.Errorf("Got:\n%v\nWanted:\n%v", level, LEVEL_ERROR)
+	}
+	if level, _, _ := spec.Detect(`{"sev":"warning","level":"info"}`); level != LEVEL_WARN {
+		t.Errorf("Got:\n%v\nWanted:\n%v", level, LEVEL_WARN)
+	}
+	for _, invalid := range []*LevelSpec{{Pattern: "("}, {Names: map[string]string{"L": "loud"}}} {
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("'%+v' should not have passed validation", invalid)
+		}
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	if l, err := ParseLevel("WARNING"); err != nil || l != LEVEL_WARN {
+		t.Errorf("Got:\n%v %v\nWanted:\n%v", l, err, LEVEL_WARN)
+	}
+	if _, err := ParseLevel("loud"); err == nil {
+		t.Errorf("Unknown level should be an error")
+	}
+}
+
+func TestMinLevel(t *testing.T) {
+	lines := newFilterLines(nil, map[string]*FilterSpec{}, LEVEL_WARN)
+	tests := []struct {
+		level Level
+		want  bool
+	}{
+		{LEVEL_INFO, false},
+		{LEVEL_WARN, true},
+		{LEVEL_FATAL, true},
+		{LEVEL_UNKNOWN, true},
+	}
+	for _, tt := range tests {
+		if got := lines.keep(&Event{Host: "host1", Level: tt.level}); got != tt.want {
+			t.Errorf("%v Got:\n%v\nWanted:\n%v", tt.level, got, tt.want)
+		}
+	}
+}
+
+func TestHighlightLevel(t *testing.T) {
+	line := "10:42:00 ERROR boom"
+	e := &Event{Host: "host1", Tag: "host1", Line: line}
+	e.Level, e.levelStart, e.levelEnd = (*LevelSpec)(nil).Detect(line)
+	f := textFormatter{colors: map[string]string{"host1": "\x1b[36m"}}
+	want := "\x1b[36m[ host1 ]\x1b[0m 10:42:00 \x1b[31mERROR\x1b[0m boom\n"
+	if got := f.Format(e); got != want {
+		t.Errorf("Got:\n%q\nWanted:\n%q", got, want)
+	}
+}
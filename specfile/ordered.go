@@ -0,0 +1,171 @@
+/*
+Copyright © 2020 Joseph Saylor <doug@saylorsolutions.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package specfile
+
+import (
+	"container/heap"
+	"time"
+)
+
+// DEFAULT_ORDER_WINDOW is how long lines are held to be put in order when ordering is enabled.
+const DEFAULT_ORDER_WINDOW time.Duration = 2 * time.Second
+
+// DEFAULT_TIMESTAMP_PATTERN matches timestamps like 2006-01-02T15:04:05.000Z or 2006-01-02 15:04:05.
+const DEFAULT_TIMESTAMP_PATTERN string = `\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(?:\.\d+)?(?:Z|[+-]\d{2}:?\d{2})?`
+
+var defaultTimestampLayouts = []string{
+	"2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02T15:04:05.999999999Z0700",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999Z0700",
+	"2006-01-02 15:04:05.999999999",
+}
+
+// Parse finds the timestamp in the line. Timestamps without a zone are taken to be in local time, and timestamps
+// without a year, like syslog's, are taken to be in the year the line arrived.
+func (t *TimestampSpec) Parse(line string, arrived time.Time) (time.Time, bool) {
+	if t.pattern == nil {
+		return time.Time{}, false
+	}
+	m := t.pattern.FindStringSubmatch(line)
+	if m == nil {
+		return time.Time{}, false
+	}
+	text := m[0]
+	if len(m) > 1 && m[1] != "" {
+		text = m[1]
+	}
+	for _, layout := range t.layouts {
+		ts, err := time.ParseInLocation(layout, text, time.Local)
+		if err != nil {
+			continue
+		}
+		if ts.Year() == 0 {
+			ts = ts.AddDate(arrived.Year(), 0, 0)
+		}
+		return ts, true
+	}
+	return time.Time{}, false
+}
+
+// pendingEvent is an event waiting in the orderedBuffer.
+type pendingEvent struct {
+	event   *Event
+	at      time.Time
+	seq     uint64
+	emitted bool
+}
+
+// eventHeap orders pending events by their timestamp, then by arrival.
+type eventHeap []*pendingEvent
+
+func (h eventHeap) Len() int { return len(h) }
+func (h eventHeap) Less(i, j int) bool {
+	if h[i].at.Equal(h[j].at) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].at.Before(h[j].at)
+}
+func (h eventHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *eventHeap) Push(x interface{}) { *h = append(*h, x.(*pendingEvent)) }
+func (h *eventHeap) Pop() interface{} {
+	old := *h
+	p := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return p
+}
+
+// orderedBuffer holds events for a window of time after they arrive, and releases them in timestamp order. Events
+// from sources that say when they were logged are ordered by that time. Lines without a timestamp that can be parsed
+// are ordered by the time they arrived.
+type orderedBuffer struct {
+	window     time.Duration
+	timestamps map[string]*TimestampSpec
+	pending    eventHeap
+	arrivals   []*pendingEvent
+	seq        uint64
+}
+
+func newOrderedBuffer(window time.Duration, timestamps map[string]*TimestampSpec) *orderedBuffer {
+	return &orderedBuffer{window: window, timestamps: timestamps}
+}
+
+// add buffers the event.
+func (b *orderedBuffer) add(e *Event) {
+	at := e.Time
+	if !e.Logged.IsZero() {
+		at = e.Logged
+	} else if ts, found := b.timestamps[e.Host]; found {
+		if parsed, ok := ts.Parse(e.Line, e.Time); ok {
+			at = parsed
+		}
+	}
+	b.seq++
+	p := &pendingEvent{event: e, at: at, seq: b.seq}
+	heap.Push(&b.pending, p)
+	b.arrivals = append(b.arrivals, p)
+}
+
+// ready releases events in order until every event that has been held for the whole window is released.
+func (b *orderedBuffer) ready(now time.Time) []*Event {
+	events := []*Event{}
+	for b.expired(now) {
+		events = append(events, b.pop())
+	}
+	return events
+}
+
+// flush releases all of the buffered events in order.
+func (b *orderedBuffer) flush() []*Event {
+	events := []*Event{}
+	for len(b.pending) > 0 {
+		events = append(events, b.pop())
+	}
+	return events
+}
+
+// next returns how long until the oldest buffered event has been held for the whole window, and false if there are
+// no buffered events.
+func (b *orderedBuffer) next(now time.Time) (time.Duration, bool) {
+	b.skipEmitted()
+	if len(b.arrivals) == 0 {
+		return 0, false
+	}
+	wait := b.arrivals[0].event.Time.Add(b.window).Sub(now)
+	if wait < 0 {
+		wait = 0
+	}
+	return wait, true
+}
+
+func (b *orderedBuffer) expired(now time.Time) bool {
+	b.skipEmitted()
+	return len(b.arrivals) > 0 && !b.arrivals[0].event.Time.Add(b.window).After(now)
+}
+
+func (b *orderedBuffer) skipEmitted() {
+	for len(b.arrivals) > 0 && b.arrivals[0].emitted {
+		b.arrivals[0] = nil
+		b.arrivals = b.arrivals[1:]
+	}
+}
+
+func (b *orderedBuffer) pop() *Event {
+	p := heap.Pop(&b.pending).(*pendingEvent)
+	p.emitted = true
+	return p.event
+}
@@ -0,0 +1,88 @@
+/*
+Copyright © 2020 Joseph Saylor <doug@saylorsolutions.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package specfile
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	arrived := time.Date(2020, 6, 1, 12, 0, 0, 0, time.Local)
+	tests := []struct {
+		spec TimestampSpec
+		line string
+		want time.Time
+		ok   bool
+	}{
+		{TimestampSpec{}, "2020-05-31T10:11:12.5Z INFO started", time.Date(2020, 5, 31, 10, 11, 12, 500000000, time.UTC), true},
+		{TimestampSpec{}, "INFO 2020-05-31 10:11:12 started", time.Date(2020, 5, 31, 10, 11, 12, 0, time.Local), true},
+		{TimestampSpec{Layout: "Jan _2 15:04:05", Pattern: `^(\w{3} [ \d]\d \d{2}:\d{2}:\d{2})`}, "May  3 10:11:12 host sshd[1]: hello", time.Date(2020, 5, 3, 10, 11, 12, 0, time.Local), true},
+		{TimestampSpec{Layout: "02/Jan/2006:15:04:05 -0700", Pattern: `\[([^]]+)\]`}, `127.0.0.1 - - [31/May/2020:10:11:12 +0000] "GET / HTTP/1.1"`, time.Date(2020, 5, 31, 10, 11, 12, 0, time.UTC), true},
+		{TimestampSpec{}, "no timestamp here", time.Time{}, false},
+	}
+	for i, tt := range tests {
+		if err := tt.spec.Validate(); err != nil {
+			t.Fatalf("tests[%d] didn't validate: %v", i, err)
+		}
+		got, ok := tt.spec.Parse(tt.line, arrived)
+		if ok != tt.ok || !got.Equal(tt.want) {
+			t.Errorf("tests[%d] Got:\n%v %v\nWanted:\n%v %v", i, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	invalid := TimestampSpec{Pattern: "("}
+	if err := invalid.Validate(); err == nil {
+		t.Errorf("Invalid pattern should not have passed validation")
+	}
+}
+
+func TestOrderedBuffer(t *testing.T) {
+	ts := &TimestampSpec{}
+	ts.Validate()
+	start := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	buf := newOrderedBuffer(time.Second, map[string]*TimestampSpec{"a": ts, "b": ts})
+
+	buf.add(&Event{Host: "a", Time: start, Line: "2020-06-01T11:59:59.300Z third"})
+	buf.add(&Event{Host: "b", Time: start.Add(100 * time.Millisecond), Line: "2020-06-01T11:59:59.100Z first"})
+	buf.add(&Event{Host: "c", Time: start.Add(200 * time.Millisecond), Line: "no timestamp"})
+	buf.add(&Event{Host: "b", Time: start.Add(300 * time.Millisecond), Line: "2020-06-01T11:59:59.200Z second"})
+
+	if got := buf.ready(start.Add(500 * time.Millisecond)); len(got) != 0 {
+		t.Errorf("Got:\n%d events\nWanted:\nnone before the window passed", len(got))
+	}
+	if wait, ok := buf.next(start.Add(500 * time.Millisecond)); !ok || wait != 500*time.Millisecond {
+		t.Errorf("Got:\n%v %v\nWanted:\n%v true", wait, ok, 500*time.Millisecond)
+	}
+
+	expectOrder := func(got []*Event, want ...string) {
+		t.Helper()
+		if len(got) != len(want) {
+			t.Fatalf("Got:\n%d events\nWanted:\n%d events", len(got), len(want))
+		}
+		for i := range want {
+			if got[i].Line[len(got[i].Line)-len(want[i]):] != want[i] {
+				t.Errorf("Got:\n%s\nWanted:\n%s", got[i].Line, want[i])
+			}
+		}
+	}
+	// The first line to arrive has been held for the window, so everything logged before it is released too.
+	expectOrder(buf.ready(start.Add(time.Second)), "first", "second", "third")
+	expectOrder(buf.flush(), "no timestamp")
+	if _, ok := buf.next(start); ok {
+		t.Errorf("Got:\npending events\nWanted:\nempty buffer")
+	}
+}
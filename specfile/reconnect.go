@@ -0,0 +1,231 @@
+/*
+Copyright © 2020 Joseph Saylor <doug@saylorsolutions.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package specfile
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// PROBE_TIMEOUT is how long to wait for a host to answer before its connection is considered broken.
+const PROBE_TIMEOUT time.Duration = 10 * time.Second
+
+var errHostClosed = errors.New("Host connection has been closed")
+
+// errNotConnected is returned when a host's connection is needed but it couldn't be established.
+var errNotConnected = errors.New("Not connected")
+
+// hostClient holds the connection to a host. It's shared by all of the host's tail sessions, and is replaced when the
+// connection is lost.
+type hostClient struct {
+	HostTag   string
+	target    *dialTarget
+	jumps     []*dialTarget
+	pool      *jumpPool
+	reconnect ReconnectSpec
+	mu        sync.Mutex
+	client    *ssh.Client
+	closed    bool
+	done      chan struct{}
+	// connected is set once the host has been connected to, and lastErr is why the last attempt to connect failed.
+	connected bool
+	lastErr   error
+	// dialing is closed when the dial that's in progress is done, and is nil when the host isn't being dialed.
+	dialing chan struct{}
+}
+
+func newHostClient(hostTag string, target *dialTarget, jumps []*dialTarget, pool *jumpPool, reconnect ReconnectSpec) *hostClient {
+	return &hostClient{HostTag: hostTag, target: target, jumps: jumps, pool: pool, reconnect: reconnect, done: make(chan struct{})}
+}
+
+// connect establishes the initial connection.
+func (h *hostClient) connect() error {
+	_, err := h.redial(nil)
+	return err
+}
+
+// neverConnected returns whether the host was never connected to, along with why the last attempt failed.
+func (h *hostClient) neverConnected() (bool, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return !h.connected, h.lastErr
+}
+
+// Client returns the current connection, which is nil if the host isn't connected.
+func (h *hostClient) Client() *ssh.Client {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.client
+}
+
+// redial replaces the broken connection with a new one. If another session already replaced it, then the new
+// connection is returned instead, so a host is only dialed once no matter how many sessions noticed the failure. The
+// lock isn't held while dialing, and nothing waits for the dial once the host is closed, so closing the host doesn't
+// wait on a host that isn't answering.
+func (h *hostClient) redial(broken *ssh.Client) (*ssh.Client, error) {
+	h.mu.Lock()
+	for h.dialing != nil {
+		dialing := h.dialing
+		h.mu.Unlock()
+		select {
+		case <-dialing:
+		case <-h.done:
+			return nil, errHostClosed
+		}
+		h.mu.Lock()
+	}
+	if h.closed {
+		h.mu.Unlock()
+		return nil, errHostClosed
+	}
+	if h.client != nil && h.client != broken {
+		client := h.client
+		h.mu.Unlock()
+		return client, nil
+	}
+	if h.client != nil {
+		h.client.Close()
+		h.client = nil
+	}
+	dialing := make(chan struct{})
+	h.dialing = dialing
+	h.mu.Unlock()
+
+	type result struct {
+		client *ssh.Client
+		err    error
+	}
+	dialed := make(chan result, 1)
+	go func() {
+		client, err := h.pool.dial(h.jumps, h.target, h.HostTag)
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.dialing = nil
+		close(dialing)
+		if err == nil && h.closed {
+			client.Close()
+			client, err = nil, errHostClosed
+		}
+		if err == nil {
+			h.client = client
+			h.connected = true
+		} else if err != errHostClosed {
+			h.lastErr = err
+		}
+		dialed <- result{client, err}
+	}()
+	select {
+	case r := <-dialed:
+		return r.client, r.err
+	case <-h.done:
+		return nil, errHostClosed
+	}
+}
+
+// Close disconnects from the host and prevents any further reconnection.
+func (h *hostClient) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.closed {
+		h.closed = true
+		close(h.done)
+	}
+	if h.client != nil {
+		return h.client.Close()
+	}
+	return nil
+}
+
+// probe checks that the host is still answering requests on the connection.
+func probe(client *ssh.Client, timeout time.Duration) error {
+	if client == nil {
+		return errNotConnected
+	}
+	result := make(chan error, 1)
+	go func() {
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		result <- err
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(timeout):
+		return errors.New("Timed out waiting for the host to respond")
+	}
+}
+
+// keepAlive sends a keepalive request on the connection every interval until it's closed. If the peer leaves MaxMissed
+// requests in a row unanswered, then the connection is closed, so anything using it fails and goes on to reconnect
+// instead of waiting forever on a connection that was silently dropped.
+func keepAlive(client *ssh.Client, spec KeepaliveSpec, name string) {
+	if spec.Interval <= 0 {
+		return
+	}
+	closed := make(chan struct{})
+	go func() {
+		client.Wait()
+		close(closed)
+	}()
+	go func() {
+		ticker := time.NewTicker(spec.Interval)
+		defer ticker.Stop()
+		missed := 0
+		for {
+			select {
+			case <-closed:
+				return
+			case <-ticker.C:
+			}
+			if probe(client, spec.Interval) == nil {
+				missed = 0
+				continue
+			}
+			select {
+			case <-closed:
+				// The connection was closed while waiting, which isn't a missed keepalive.
+				return
+			default:
+			}
+			missed++
+			if missed >= spec.MaxMissed {
+				statusf("%s missed %d keepalives in a row, closing the connection\n", name, missed)
+				client.Close()
+				return
+			}
+		}
+	}()
+}
+
+// backoff calculates how long to wait before the given reconnection attempt. The delay doubles with each attempt up to
+// the configured maximum, and a random jitter of up to half the delay keeps hosts from reconnecting in lockstep.
+func backoff(attempt int, spec ReconnectSpec) time.Duration {
+	delay := spec.InitialBackoff
+	for i := 1; i < attempt && delay < spec.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > spec.MaxBackoff {
+		delay = spec.MaxBackoff
+	}
+	half := delay / 2
+	if half <= 0 {
+		return delay
+	}
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
@@ -0,0 +1,325 @@
+/*
+Copyright © 2020 Joseph Saylor <doug@saylorsolutions.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package specfile
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestBackoff(t *testing.T) {
+	spec := ReconnectSpec{MaxRetries: 5, InitialBackoff: time.Second, MaxBackoff: 10 * time.Second}
+	bounds := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	for i, max := range bounds {
+		attempt := i + 1
+		for j := 0; j < 20; j++ {
+			got := backoff(attempt, spec)
+			if got < max/2 || got > max {
+				t.Fatalf("Attempt %d backoff %v should be between %v and %v", attempt, got, max/2, max)
+			}
+		}
+	}
+}
+
+func TestReconnectDefaults(t *testing.T) {
+	var spec ReconnectSpec
+	if err := spec.Validate(); err != nil {
+		t.Fatalf("Empty reconnect spec should be valid: %v", err)
+	}
+	if spec.MaxRetries != DEFAULT_MAX_RETRIES || spec.InitialBackoff != DEFAULT_INITIAL_BACKOFF || spec.MaxBackoff != DEFAULT_MAX_BACKOFF {
+		t.Errorf("Reconnect defaults were not set: %+v", spec)
+	}
+
+	spec = ReconnectSpec{InitialBackoff: -time.Second}
+	if err := spec.Validate(); err == nil {
+		t.Error("Negative backoff should not pass validation")
+	}
+}
+
+func TestConnectHostsRequired(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	config := &ssh.ClientConfig{HostKeyCallback: ssh.InsecureIgnoreHostKey(), Timeout: time.Second}
+	unreachable := func(tag string) *hostClient {
+		return newHostClient(tag, &dialTarget{addr, config}, nil, newJumpPool(KeepaliveSpec{}), ReconnectSpec{})
+	}
+	hosts := []*hostClient{unreachable("host1"), unreachable("host2")}
+
+	tests := map[int]string{
+		2: "Failed to connect to " + addr,
+		1: "Fewer than 1 hosts can be reached",
+	}
+	for required, want := range tests {
+		err := connectHosts(hosts, required, 2, true)
+		if err == nil || !strings.HasPrefix(err.Error(), want) {
+			t.Errorf("Requiring %d hosts got:\n%v\nWanted:\n%s...", required, err, want)
+		}
+	}
+	if err := connectHosts(hosts, 0, 1, true); err != nil {
+		t.Errorf("No hosts are required, but got: %v", err)
+	}
+}
+
+func TestDialTimeout(t *testing.T) {
+	// The listener accepts connections, but never starts the SSH handshake.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	config := &ssh.ClientConfig{HostKeyCallback: ssh.InsecureIgnoreHostKey(), Timeout: 100 * time.Millisecond}
+	target := &dialTarget{listener.Addr().String(), config}
+	hosts := []*hostClient{}
+	for _, tag := range []string{"host1", "host2", "host3", "host4"} {
+		hosts = append(hosts, newHostClient(tag, target, nil, newJumpPool(KeepaliveSpec{}), ReconnectSpec{}))
+	}
+
+	start := time.Now()
+	err = connectHosts(hosts, len(hosts), len(hosts), true)
+	if err == nil || !strings.Contains(err.Error(), "Timed out after 100ms waiting for the SSH handshake") {
+		t.Errorf("Got:\n%v\nWanted a handshake timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Hosts should be connected to at once, but it took %v", elapsed)
+	}
+}
+
+func TestSharedJumpHostTimeout(t *testing.T) {
+	// The jump host accepts connections, but never starts the SSH handshake.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	accepted := make(chan struct{}, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- struct{}{}
+			defer conn.Close()
+		}
+	}()
+
+	timeout := 300 * time.Millisecond
+	config := &ssh.ClientConfig{HostKeyCallback: ssh.InsecureIgnoreHostKey(), Timeout: timeout}
+	jumps := []*dialTarget{{listener.Addr().String(), config}}
+	pool := newJumpPool(KeepaliveSpec{})
+	defer pool.Close()
+	hosts := []*hostClient{
+		newHostClient("host1", &dialTarget{"host1:22", config}, jumps, pool, ReconnectSpec{}),
+		newHostClient("host2", &dialTarget{"host2:22", config}, jumps, pool, ReconnectSpec{}),
+	}
+
+	start := time.Now()
+	if err := connectHosts(hosts, 0, len(hosts), false); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > timeout*3/2 {
+		t.Errorf("Hosts behind the same jump host should share its failure, but it took %v", elapsed)
+	}
+	for _, host := range hosts {
+		never, err := host.neverConnected()
+		if !never || err == nil || !strings.Contains(err.Error(), "Failed to connect to jump host") {
+			t.Errorf("Got:\n%v %v\nWanted a jump host failure for %s", never, err, host.HostTag)
+		}
+		host.Close()
+	}
+	if len(accepted) != 1 {
+		t.Errorf("Got:\n%v\nWanted:\n%v", len(accepted), 1)
+	}
+}
+
+func TestKeepaliveDefaults(t *testing.T) {
+	var spec KeepaliveSpec
+	if err := spec.Validate(); err != nil {
+		t.Fatalf("Empty keepalive spec should be valid: %v", err)
+	}
+	if spec.Interval != DEFAULT_KEEPALIVE_INTERVAL || spec.MaxMissed != DEFAULT_KEEPALIVE_MAX_MISSED {
+		t.Errorf("Keepalive defaults were not set: %+v", spec)
+	}
+
+	spec = KeepaliveSpec{MaxMissed: -1}
+	if err := spec.Validate(); err == nil {
+		t.Error("Negative max missed should not pass validation")
+	}
+}
+
+func TestKeepaliveClosesDeadConnection(t *testing.T) {
+	_, hostPriv, _ := ed25519.GenerateKey(rand.Reader)
+	hostSigner, _ := ssh.NewSignerFromKey(hostPriv)
+	serverConfig := &ssh.ServerConfig{NoClientAuth: true}
+	serverConfig.AddHostKey(hostSigner)
+
+	// The server answers keepalives until it's told to stop, like a peer behind a NAT that dropped the connection.
+	var answering int32 = 1
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		serverConn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		conn, _, reqs, err := ssh.NewServerConn(serverConn, serverConfig)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for req := range reqs {
+			if atomic.LoadInt32(&answering) == 1 {
+				req.Reply(true, nil)
+			}
+		}
+	}()
+	config := &ssh.ClientConfig{HostKeyCallback: ssh.InsecureIgnoreHostKey()}
+	client, err := ssh.Dial("tcp", l.Addr().String(), config)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	closed := make(chan struct{})
+	go func() {
+		client.Wait()
+		close(closed)
+	}()
+	keepAlive(client, KeepaliveSpec{Interval: 20 * time.Millisecond, MaxMissed: 2}, "[ host1 ]")
+
+	select {
+	case <-closed:
+		t.Fatal("Connection was closed while the peer was answering keepalives")
+	case <-time.After(200 * time.Millisecond):
+	}
+	atomic.StoreInt32(&answering, 0)
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Connection was not closed after the peer stopped answering keepalives")
+	}
+}
+
+func TestTunnelTimeout(t *testing.T) {
+	_, hostPriv, _ := ed25519.GenerateKey(rand.Reader)
+	hostSigner, _ := ssh.NewSignerFromKey(hostPriv)
+	serverConfig := &ssh.ServerConfig{NoClientAuth: true}
+	serverConfig.AddHostKey(hostSigner)
+
+	// The jump host never answers requests to open a tunnel, like one whose route to the target is black holed.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		serverConn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		conn, chans, reqs, err := ssh.NewServerConn(serverConn, serverConfig)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		go ssh.DiscardRequests(reqs)
+		for range chans {
+		}
+	}()
+	via, err := ssh.Dial("tcp", l.Addr().String(), &ssh.ClientConfig{HostKeyCallback: ssh.InsecureIgnoreHostKey()})
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer via.Close()
+
+	config := &ssh.ClientConfig{HostKeyCallback: ssh.InsecureIgnoreHostKey(), Timeout: 100 * time.Millisecond}
+	result := make(chan error, 1)
+	go func() {
+		_, err := dialVia(via, &dialTarget{"10.0.0.5:22", config})
+		result <- err
+	}()
+	select {
+	case err := <-result:
+		if err == nil || !strings.Contains(err.Error(), "Timed out after 100ms connecting to 10.0.0.5:22") {
+			t.Errorf("Got:\n%v\nWanted a tunnel timeout", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Opening a tunnel through the jump host did not time out")
+	}
+}
+
+func TestCloseWhileDialing(t *testing.T) {
+	// The listener accepts connections, but never starts the SSH handshake.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	config := &ssh.ClientConfig{HostKeyCallback: ssh.InsecureIgnoreHostKey(), Timeout: time.Second}
+	host := newHostClient("host1", &dialTarget{listener.Addr().String(), config}, nil, newJumpPool(KeepaliveSpec{}), ReconnectSpec{})
+	connected := make(chan error, 1)
+	go func() {
+		connected <- host.connect()
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	start := time.Now()
+	host.Close()
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Closing the host waited %v for the dial to finish", elapsed)
+	}
+	if err := <-connected; err == nil {
+		t.Error("Connecting to a host that was closed should be an error")
+	}
+	if host.Client() != nil {
+		t.Error("A host that was closed while dialing should not keep the connection")
+	}
+}
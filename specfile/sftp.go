@@ -0,0 +1,283 @@
+/*
+Copyright © 2020 Joseph Saylor <doug@saylorsolutions.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package specfile
+
+import (
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// DEFAULT_POLL_INTERVAL is how often the SFTP follower checks the remote file for changes.
+const DEFAULT_POLL_INTERVAL time.Duration = time.Second
+
+// sftpFollower follows a remote file by polling it over the SFTP subsystem, so no remote binaries are needed.
+type sftpFollower struct {
+	client     *sftp.Client
+	file       string
+	interval   time.Duration
+	handle     *sftp.File
+	offset     int64
+	fromStart  bool
+	backfill   int
+	marker     string
+	since      time.Time
+	timestamps *TimestampSpec
+	tag        string
+	buf        []byte
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+func newSFTPFollower(client *sftp.Client, file string) *sftpFollower {
+	return &sftpFollower{
+		client:   client,
+		file:     file,
+		interval: DEFAULT_POLL_INTERVAL,
+		buf:      make([]byte, 32*1024),
+		done:     make(chan struct{}),
+	}
+}
+
+// follow writes content appended to the file until the follower is closed or the connection fails. There's no remote
+// command, so nothing is written to errOut.
+func (f *sftpFollower) follow(out io.Writer, errOut io.Writer) error {
+	defer f.closeHandle()
+
+	// Like 'tail -n 0', only content written after the follower starts is of interest unless told otherwise.
+	if info, err := f.client.Stat(f.file); err == nil && !f.fromStart {
+		f.offset = info.Size()
+		if f.marker != "" && !f.since.IsZero() {
+			if err := f.writeSince(out); err != nil {
+				return err
+			}
+		} else if f.marker != "" {
+			if err := f.writeBackfill(out); err != nil {
+				return err
+			}
+		}
+	}
+	if f.marker != "" {
+		if _, err := io.WriteString(out, f.marker+"\n"); err != nil {
+			return err
+		}
+		f.marker = ""
+	}
+
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.done:
+			return nil
+		case <-ticker.C:
+		}
+		if err := f.poll(out); err != nil {
+			select {
+			case <-f.done:
+				// Errors caused by closing the follower aren't interesting.
+				return nil
+			default:
+				return err
+			}
+		}
+	}
+}
+
+// poll checks the file once, detecting truncation and rotation, and writes any new content to out.
+func (f *sftpFollower) poll(out io.Writer) error {
+	info, err := f.client.Stat(f.file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Probably in the middle of a rotation, check again later.
+			return nil
+		}
+		return err
+	}
+	size := info.Size()
+
+	if size < f.offset || f.rotated(size) {
+		// Whatever is left in the old file is read before starting over at the beginning of the new one.
+		if f.handle != nil {
+			if err := f.copyNew(out); err != nil {
+				return err
+			}
+			f.closeHandle()
+		}
+		f.offset = 0
+	}
+	if size == f.offset {
+		return nil
+	}
+
+	if f.handle == nil {
+		handle, err := f.client.Open(f.file)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		f.handle = handle
+	}
+	return f.copyNew(out)
+}
+
+// rotated reports whether the path now refers to a different file than the open handle. The open file can only have
+// grown since the path was checked, so a path that's larger than the handle must be a new file.
+func (f *sftpFollower) rotated(pathSize int64) bool {
+	if f.handle == nil {
+		return false
+	}
+	info, err := f.handle.Stat()
+	return err == nil && info.Size() < pathSize
+}
+
+// copyNew writes everything from the current offset to the end of the open file.
+func (f *sftpFollower) copyNew(out io.Writer) error {
+	for {
+		n, err := f.handle.ReadAt(f.buf, f.offset)
+		if n > 0 {
+			if _, werr := out.Write(f.buf[:n]); werr != nil {
+				return werr
+			}
+			f.offset += int64(n)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// writeBackfill writes the last lines of the file before the offset. Only complete lines are written, and the offset
+// is moved back to the end of the last one so the rest of a partial line is followed.
+func (f *sftpFollower) writeBackfill(out io.Writer) error {
+	handle, err := f.client.Open(f.file)
+	if err != nil {
+		return err
+	}
+	f.handle = handle
+	start, end, err := lastLines(handle, f.offset, f.backfill)
+	if err != nil {
+		return err
+	}
+	f.offset = start
+	return f.copyTo(out, end)
+}
+
+// writeSince writes the lines logged since the start time up to the offset. Only complete lines are written, like
+// with writeBackfill.
+func (f *sftpFollower) writeSince(out io.Writer) error {
+	handle, err := f.client.Open(f.file)
+	if err != nil {
+		return err
+	}
+	f.handle = handle
+	_, end, err := lastLines(handle, f.offset, 1)
+	if err != nil {
+		return err
+	}
+	start, err := findSince(handle, end, f.since, f.timestamps)
+	if err == errNoTimestamps {
+		statusf("[ %s ] can't find the lines logged since the start time, only following new lines: %v\n", f.tag, err)
+		f.offset = end
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	f.offset = start
+	return f.copyTo(out, end)
+}
+
+// copyTo writes the open file from the offset to the end position.
+func (f *sftpFollower) copyTo(out io.Writer, end int64) error {
+	for f.offset < end {
+		chunk := f.buf
+		if remaining := end - f.offset; remaining < int64(len(chunk)) {
+			chunk = chunk[:remaining]
+		}
+		n, err := f.handle.ReadAt(chunk, f.offset)
+		if n > 0 {
+			if _, werr := out.Write(chunk[:n]); werr != nil {
+				return werr
+			}
+			f.offset += int64(n)
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if n == 0 {
+			break
+		}
+	}
+	return nil
+}
+
+// lastLines finds where the last n complete lines before size start and end in the file.
+func lastLines(r io.ReaderAt, size int64, n int) (start int64, end int64, err error) {
+	buf := make([]byte, 32*1024)
+	end = -1
+	newlines := 0
+	for pos := size; pos > 0; {
+		chunk := int64(len(buf))
+		if pos < chunk {
+			chunk = pos
+		}
+		pos -= chunk
+		if _, err := r.ReadAt(buf[:chunk], pos); err != nil && err != io.EOF {
+			return 0, 0, err
+		}
+		for i := chunk - 1; i >= 0; i-- {
+			if buf[i] != '\n' {
+				continue
+			}
+			if end < 0 {
+				end = pos + i + 1
+				continue
+			}
+			newlines++
+			if newlines == n {
+				return pos + i + 1, end, nil
+			}
+		}
+	}
+	if end < 0 {
+		return 0, 0, nil
+	}
+	return 0, end, nil
+}
+
+func (f *sftpFollower) closeHandle() {
+	if f.handle != nil {
+		f.handle.Close()
+		f.handle = nil
+	}
+}
+
+// Close stops following the file and closes the SFTP session.
+func (f *sftpFollower) Close() error {
+	f.closeOnce.Do(func() {
+		close(f.done)
+	})
+	return f.client.Close()
+}
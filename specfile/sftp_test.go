@@ -0,0 +1,183 @@
+/*
+Copyright © 2020 Joseph Saylor <doug@saylorsolutions.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package specfile
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/sftp"
+)
+
+// newTestSFTPClient connects an SFTP client to an in-process server backed by the local file system.
+func newTestSFTPClient(t *testing.T) *sftp.Client {
+	serverConn, clientConn := net.Pipe()
+	server, err := sftp.NewServer(serverConn)
+	if err != nil {
+		t.Fatalf("Failed to create SFTP server: %v", err)
+	}
+	go server.Serve()
+	client, err := sftp.NewClientPipe(clientConn, clientConn)
+	if err != nil {
+		t.Fatalf("Failed to create SFTP client: %v", err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client
+}
+
+func appendFile(t *testing.T, name string, text string) {
+	f, err := os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open '%s': %v", name, err)
+	}
+	defer f.Close()
+	if _, err = f.WriteString(text); err != nil {
+		t.Fatalf("Failed to write to '%s': %v", name, err)
+	}
+}
+
+func pollExpect(t *testing.T, f *sftpFollower, out *bytes.Buffer, want string) {
+	t.Helper()
+	out.Reset()
+	if err := f.poll(out); err != nil {
+		t.Fatalf("Failed to poll: %v", err)
+	}
+	if got := out.String(); got != want {
+		t.Errorf("Got:\n%q\nWanted:\n%q", got, want)
+	}
+}
+
+func TestSFTPFollowAppended(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sshtail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "test.log")
+	appendFile(t, name, "before start\n")
+
+	f := newSFTPFollower(newTestSFTPClient(t), name)
+	defer f.closeHandle()
+	f.offset = int64(len("before start\n"))
+	var out bytes.Buffer
+
+	pollExpect(t, f, &out, "")
+	appendFile(t, name, "line 1\n")
+	pollExpect(t, f, &out, "line 1\n")
+	appendFile(t, name, "line 2\nline 3\n")
+	pollExpect(t, f, &out, "line 2\nline 3\n")
+}
+
+func TestSFTPFollowTruncated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sshtail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "test.log")
+	appendFile(t, name, "")
+
+	f := newSFTPFollower(newTestSFTPClient(t), name)
+	defer f.closeHandle()
+	var out bytes.Buffer
+
+	appendFile(t, name, "a long line before truncation\n")
+	pollExpect(t, f, &out, "a long line before truncation\n")
+	if err := os.Truncate(name, 0); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, name, "short\n")
+	pollExpect(t, f, &out, "short\n")
+}
+
+func TestSFTPFollowRotated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sshtail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "test.log")
+	appendFile(t, name, "")
+
+	f := newSFTPFollower(newTestSFTPClient(t), name)
+	defer f.closeHandle()
+	var out bytes.Buffer
+
+	appendFile(t, name, "old 1\n")
+	pollExpect(t, f, &out, "old 1\n")
+	appendFile(t, name, "old 2\n")
+	if err := os.Rename(name, name+".1"); err != nil {
+		t.Fatal(err)
+	}
+	pollExpect(t, f, &out, "")
+	appendFile(t, name, "a new file that is larger than the old one\n")
+	pollExpect(t, f, &out, "old 2\na new file that is larger than the old one\n")
+}
+
+func TestSFTPBackfill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sshtail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "test.log")
+	appendFile(t, name, "line 1\nline 2\nline 3\npartial")
+
+	f := newSFTPFollower(newTestSFTPClient(t), name)
+	defer f.closeHandle()
+	f.offset = int64(len("line 1\nline 2\nline 3\npartial"))
+	f.backfill = 2
+	var out bytes.Buffer
+
+	if err := f.writeBackfill(&out); err != nil {
+		t.Fatalf("Failed to backfill: %v", err)
+	}
+	if got, want := out.String(), "line 2\nline 3\n"; got != want {
+		t.Errorf("Got:\n%q\nWanted:\n%q", got, want)
+	}
+	appendFile(t, name, " line\n")
+	pollExpect(t, f, &out, "partial line\n")
+}
+
+func TestLastLines(t *testing.T) {
+	tests := []struct {
+		text  string
+		n     int
+		lines string
+	}{
+		{"a\nb\nc\n", 2, "b\nc\n"},
+		{"a\nb\nc\n", 5, "a\nb\nc\n"},
+		{"a\nb\nc", 1, "b\n"},
+		{"no newline", 3, ""},
+		{"", 3, ""},
+	}
+	for _, tt := range tests {
+		start, end, err := lastLines(bytes.NewReader([]byte(tt.text)), int64(len(tt.text)), tt.n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := tt.text[start:end]; got != tt.lines {
+			t.Errorf("Got:\n%q\nWanted:\n%q", got, tt.lines)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+/*
+Copyright © 2020 Joseph Saylor <doug@saylorsolutions.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package specfile
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SINCE_SCAN_CHUNK is how much of the file is read at each step of the search for the start time.
+const SINCE_SCAN_CHUNK int = 64 * 1024
+
+// errNoTimestamps is returned when searching a file for the start time finds no lines with a timestamp.
+var errNoTimestamps = errors.New("No lines have a timestamp matching the host's timestamp pattern")
+
+// sinceLayouts are the timestamp formats accepted by ParseSince. Layouts without a date are taken to be today.
+var sinceLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"15:04:05",
+	"15:04",
+}
+
+// ParseSince parses a start time given either as a duration before now, like '30m', or as a timestamp, like
+// '2020-05-01 10:42' or '10:42'. Timestamps without a zone are in local time.
+func ParseSince(since string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(since); err == nil {
+		if d < 0 {
+			d = -d
+		}
+		return now.Add(-d), nil
+	}
+	for _, layout := range sinceLayouts {
+		t, err := time.ParseInLocation(layout, since, time.Local)
+		if err != nil {
+			continue
+		}
+		if t.Year() == 0 {
+			y, m, d := now.In(time.Local).Date()
+			t = time.Date(y, m, d, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.Local)
+		}
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("Invalid start time '%s', must be a duration like 30m or a timestamp like 2006-01-02 15:04:05", since)
+}
+
+// findSince searches the first size bytes of the file for the first line logged at or after since, assuming lines are
+// mostly in order. The offset returned is the start of a line before it, so the search only needs to get close and the
+// lines in between are dropped by the writer. If none of the lines looked at have a timestamp, then errNoTimestamps is
+// returned, since the writer would drop every line anyway.
+func findSince(r io.ReaderAt, size int64, since time.Time, ts *TimestampSpec) (int64, error) {
+	lo, hi := int64(0), size
+	found := false
+	for hi-lo > int64(SINCE_SCAN_CHUNK) {
+		mid := lo + (hi-lo)/2
+		start, t, ok, err := firstTimestamp(r, mid, size, ts)
+		if err != nil {
+			return 0, err
+		}
+		found = found || ok
+		if ok && t.Before(since) {
+			lo = start
+		} else {
+			hi = mid
+		}
+	}
+	if !found && size > 0 {
+		_, _, ok, err := firstTimestamp(r, lo, size, ts)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			return 0, errNoTimestamps
+		}
+	}
+	return lo, nil
+}
+
+// firstTimestamp finds the first complete line at or after the offset that has a timestamp, looking no further than a
+// single chunk.
+func firstTimestamp(r io.ReaderAt, off int64, size int64, ts *TimestampSpec) (int64, time.Time, bool, error) {
+	buf := make([]byte, SINCE_SCAN_CHUNK)
+	if remaining := size - off; remaining < int64(len(buf)) {
+		buf = buf[:remaining]
+	}
+	n, err := r.ReadAt(buf, off)
+	if err != nil && err != io.EOF {
+		return 0, time.Time{}, false, err
+	}
+	buf = buf[:n]
+	pos := 0
+	if off > 0 {
+		// The offset is probably in the middle of a line.
+		i := bytes.IndexByte(buf, '\n')
+		if i < 0 {
+			return 0, time.Time{}, false, nil
+		}
+		pos = i + 1
+	}
+	for {
+		i := bytes.IndexByte(buf[pos:], '\n')
+		if i < 0 {
+			return 0, time.Time{}, false, nil
+		}
+		if t, ok := ts.Parse(string(buf[pos:pos+i]), time.Now()); ok {
+			return off + int64(pos), t, true, nil
+		}
+		pos += i + 1
+	}
+}
+
+// execReader reads parts of a remote file by running commands on the host, for when SFTP isn't used.
+type execReader struct {
+	client *ssh.Client
+	file   string
+}
+
+func (e *execReader) run(command string) ([]byte, error) {
+	session, err := e.client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("Error establishing session: %v", err)
+	}
+	defer session.Close()
+	return session.Output(command)
+}
+
+// size returns the size of the file.
+func (e *execReader) size() (int64, error) {
+	out, err := e.run(fmt.Sprintf("wc -c < %s", shellQuote(e.file)))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+}
+
+func (e *execReader) ReadAt(p []byte, off int64) (int, error) {
+	out, err := e.run(fmt.Sprintf("tail -c +%d %s | head -c %d", off+1, shellQuote(e.file), len(p)))
+	if err != nil {
+		return 0, err
+	}
+	n := copy(p, out)
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,140 @@
+/*
+Copyright © 2020 Joseph Saylor <doug@saylorsolutions.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package specfile
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2020, 5, 1, 12, 0, 0, 0, time.Local)
+	tests := []struct {
+		since string
+		want  time.Time
+	}{
+		{"30m", now.Add(-30 * time.Minute)},
+		{"2020-04-30T10:42:00Z", time.Date(2020, 4, 30, 10, 42, 0, 0, time.UTC)},
+		{"2020-04-30 10:42", time.Date(2020, 4, 30, 10, 42, 0, 0, time.Local)},
+		{"10:42", time.Date(2020, 5, 1, 10, 42, 0, 0, time.Local)},
+	}
+	for _, tt := range tests {
+		got, err := ParseSince(tt.since, now)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("'%s' Got:\n%v %v\nWanted:\n%v", tt.since, got, err, tt.want)
+		}
+	}
+	if _, err := ParseSince("yesterday", now); err == nil {
+		t.Errorf("Invalid start time should be an error")
+	}
+}
+
+func TestFindSince(t *testing.T) {
+	start := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	var sb strings.Builder
+	target := -1
+	since := start.Add(20000 * time.Second)
+	for i := 0; i < 40000; i++ {
+		if i == 20000 {
+			target = sb.Len()
+		}
+		fmt.Fprintf(&sb, "%s INFO request %d\n", start.Add(time.Duration(i)*time.Second).Format(time.RFC3339), i)
+		if i%10 == 0 {
+			sb.WriteString("    a line without a timestamp\n")
+		}
+	}
+	text := sb.String()
+	ts := &TimestampSpec{}
+	ts.Validate()
+
+	offset, err := findSince(bytes.NewReader([]byte(text)), int64(len(text)), since, ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset > int64(target) || int64(target)-offset > int64(2*SINCE_SCAN_CHUNK) {
+		t.Errorf("Got:\n%d\nWanted:\nan offset shortly before %d", offset, target)
+	}
+	if offset > 0 && text[offset-1] != '\n' {
+		t.Errorf("Offset %d isn't the start of a line", offset)
+	}
+
+	offset, _ = findSince(bytes.NewReader([]byte(text)), int64(len(text)), start.Add(-time.Hour), ts)
+	if offset != 0 {
+		t.Errorf("Got:\n%d\nWanted:\n0", offset)
+	}
+}
+
+func TestFindSinceNoTimestamps(t *testing.T) {
+	ts := &TimestampSpec{}
+	ts.Validate()
+	since := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+	var sb strings.Builder
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&sb, "May  1 10:%02d:00 remote-host-1 app[42]: request %d\n", i%60, i)
+	}
+	large := sb.String()
+	small := large[:200]
+	for _, text := range []string{small, large} {
+		if _, err := findSince(bytes.NewReader([]byte(text)), int64(len(text)), since, ts); err != errNoTimestamps {
+			t.Errorf("Searching %d bytes got:\n%v\nWanted:\n%v", len(text), err, errNoTimestamps)
+		}
+	}
+}
+
+func TestSFTPSinceNoTimestamps(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sshtail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "syslog")
+	text := "May  1 10:41:00 remote-host-1 app[42]: before\nMay  1 10:43:00 remote-host-1 app[42]: after\n"
+	appendFile(t, name, text)
+
+	f := newSFTPFollower(newTestSFTPClient(t), name)
+	defer f.closeHandle()
+	f.offset = int64(len(text))
+	f.since = time.Date(2020, 5, 1, 10, 42, 0, 0, time.UTC)
+	f.timestamps = &TimestampSpec{}
+	f.timestamps.Validate()
+	var out bytes.Buffer
+
+	if err := f.writeSince(&out); err != nil {
+		t.Fatalf("A file without timestamps should not be an error: %v", err)
+	}
+	if out.Len() != 0 || f.offset != int64(len(text)) {
+		t.Errorf("Got:\n%q at offset %d\nWanted:\nnothing written, following from %d", out.String(), f.offset, len(text))
+	}
+}
+
+func TestWriterSince(t *testing.T) {
+	spec := &HostSpec{Hostname: "remote-host-1", File: "/var/log/syslog"}
+	spec.Validate()
+	pair := &ClientFilePair{HostTag: "host1", File: spec.File, Mode: MODE_TAIL, Tag: "host1", Spec: spec}
+	ch := make(chan *Event, 10)
+	w := NewTailChannelWriter(pair, ch)
+	defer w.Flush()
+	w.backfillUntil("marker", time.Date(2020, 5, 1, 10, 42, 0, 0, time.UTC))
+
+	w.Write([]byte("2020-05-01T10:41:59Z before\n  continued\n2020-05-01T10:42:00Z at\n  continued\n2020-05-01T10:41:00Z late\nmarker\nlive\n"))
+	expectLines(t, ch, "[ host1 ~] 2020-05-01T10:42:00Z at\n", "[ host1 ~]   continued\n", "[ host1 ~] 2020-05-01T10:41:00Z late\n", "[ host1 ] live\n")
+}
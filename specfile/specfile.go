@@ -23,13 +23,35 @@ import (
 	"io/ioutil"
 	"os/user"
 	"path"
+	"regexp"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 const DEFAULT_SSH_PORT int = 22
 
+// Modes used to follow a remote file.
+const (
+	// MODE_TAIL runs the 'tail' executable on the remote host.
+	MODE_TAIL string = "tail"
+	// MODE_SFTP polls the file over the SFTP subsystem, which doesn't depend on any remote executables.
+	MODE_SFTP string = "sftp"
+)
+
+// Sources that a host's lines can be read from.
+const (
+	// SOURCE_FILE follows the host's files.
+	SOURCE_FILE string = "file"
+	// SOURCE_JOURNAL follows the systemd journal with 'journalctl'.
+	SOURCE_JOURNAL string = "journal"
+	// SOURCE_DOCKER follows the output of containers with 'docker logs'.
+	SOURCE_DOCKER string = "docker"
+	// SOURCE_COMMAND follows the output of an arbitrary command.
+	SOURCE_COMMAND string = "command"
+)
+
 func defaultUsername() string {
 	u, err := user.Current()
 	if err != nil {
@@ -41,12 +63,93 @@ func defaultUsername() string {
 	return split[len(split)-1]
 }
 
-// HostSpec identifies the hostname and port to connect to, as well as the file to tail.
+// HostSpec identifies the hostname and port to connect to, as well as the files to tail and how to follow them.
+// File and Files may be used together, all files named are tailed over the same connection. Files may be glob patterns
+// or directories, which are expanded on the remote host every Rescan interval. If Source is SOURCE_JOURNAL, then the
+// systemd journal entries selected by Journal are followed instead of files, and if it's SOURCE_DOCKER, then the
+// containers selected by Docker are. Setting Command instead of a file follows the output of the command.
 type HostSpec struct {
-	Hostname string `json:"hostname" yaml:"hostname"`
-	Username string `json:"username" yaml:"username"`
-	File     string `json:"file" yaml:"file"`
-	Port     int    `json:"port" yaml:"port"`
+	Hostname  string        `json:"hostname" yaml:"hostname"`
+	Username  string        `json:"username" yaml:"username"`
+	File      string        `json:"file" yaml:"file"`
+	Files     []string      `json:"files" yaml:"files"`
+	Port      int           `json:"port" yaml:"port"`
+	Mode      string        `json:"mode" yaml:"mode"`
+	Rescan    time.Duration `json:"rescan" yaml:"rescan"`
+	Jump      JumpSpec      `json:"jump" yaml:"jump"`
+	Timestamp TimestampSpec `json:"timestamp" yaml:"timestamp"`
+	Filters   FilterSpec    `json:"filters" yaml:"filters"`
+	// RemoteFilters are applied on the remote host in tail mode, so dropped lines are never sent over the network.
+	RemoteFilters FilterSpec `json:"remoteFilters" yaml:"remoteFilters"`
+	Color         string     `json:"color" yaml:"color"`
+	Level         LevelSpec  `json:"level" yaml:"level"`
+	// Backfill is how many of the file's last lines are written before following it.
+	Backfill int         `json:"backfill" yaml:"backfill"`
+	Source   string      `json:"source" yaml:"source"`
+	Journal  JournalSpec `json:"journal" yaml:"journal"`
+	Docker   DockerSpec  `json:"docker" yaml:"docker"`
+	Command  string      `json:"command" yaml:"command"`
+	// Timeout is how long connecting to the host may take, which is applied to the SSH handshake as well.
+	Timeout time.Duration `json:"timeout" yaml:"timeout"`
+}
+
+// JumpSpec is a chain of jump hosts used to reach a host, each written as [user@]host[:port]. It may be given as a
+// single comma separated string, like OpenSSH's ProxyJump, or as a list.
+type JumpSpec []string
+
+// UnmarshalYAML accepts either a string or a list of strings.
+func (j *JumpSpec) UnmarshalYAML(value *yaml.Node) error {
+	var hops StringList
+	if err := value.Decode(&hops); err != nil {
+		return err
+	}
+	*j = JumpSpec(hops)
+	return nil
+}
+
+// String returns the chain in ProxyJump form.
+func (j JumpSpec) String() string {
+	return strings.Join(j, ",")
+}
+
+// StringList is a list of strings that may be written as a single string in YAML when it only has one entry.
+type StringList []string
+
+// UnmarshalYAML accepts either a string or a list of strings.
+func (l *StringList) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*l = StringList{value.Value}
+		return nil
+	}
+	list := []string{}
+	if err := value.Decode(&list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+// AllFiles returns the unique files named by both File and Files.
+func (h *HostSpec) AllFiles() []string {
+	files := []string{}
+	seen := map[string]bool{}
+	for _, f := range append([]string{h.File}, h.Files...) {
+		if f != "" && !seen[f] {
+			seen[f] = true
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// followsFiles reports whether the host's source is files, rather than something like the journal.
+func (h *HostSpec) followsFiles() bool {
+	return h.Source == "" || h.Source == SOURCE_FILE
+}
+
+// hasHistory reports whether the host's source has lines from before it was followed, which can be backfilled.
+func (h *HostSpec) hasHistory() bool {
+	return h.Source != SOURCE_COMMAND
 }
 
 // Validate checks the HostSpec for errors and sets reasonable defaults.
@@ -57,32 +160,279 @@ func (h *HostSpec) Validate() error {
 	if h.Username == "" {
 		h.Username = defaultUsername()
 	}
-	if h.File == "" {
-		return errors.New("Host spec cannot have a blank file")
+	if h.Source == "" && h.Command != "" {
+		h.Source = SOURCE_COMMAND
+	}
+	if h.Command != "" && h.Source != SOURCE_COMMAND {
+		return fmt.Errorf("Host spec with a %s source cannot have a command", h.Source)
+	}
+	switch h.Source {
+	case "":
+		h.Source = SOURCE_FILE
+		fallthrough
+	case SOURCE_FILE:
+		if h.File == "" && len(h.Files) == 0 {
+			return errors.New("Host spec cannot have a blank file")
+		}
+		for _, f := range h.Files {
+			if f == "" {
+				return errors.New("Host spec cannot have a blank entry in files")
+			}
+		}
+	case SOURCE_JOURNAL, SOURCE_DOCKER, SOURCE_COMMAND:
+		if h.File != "" || len(h.Files) > 0 {
+			return fmt.Errorf("Host spec with a %s source cannot have files", h.Source)
+		}
+		if h.Mode == MODE_SFTP {
+			return fmt.Errorf("Host spec with a %s source cannot use sftp mode", h.Source)
+		}
+		switch h.Source {
+		case SOURCE_JOURNAL:
+			if err := h.Journal.Validate(); err != nil {
+				return fmt.Errorf("Host spec has an invalid journal: %v", err)
+			}
+		case SOURCE_DOCKER:
+			if err := h.Docker.Validate(); err != nil {
+				return fmt.Errorf("Host spec has invalid containers: %v", err)
+			}
+		case SOURCE_COMMAND:
+			if strings.TrimSpace(h.Command) == "" {
+				return errors.New("Host spec with a command source cannot have a blank command")
+			}
+		}
+	default:
+		return fmt.Errorf("Host spec has unknown source '%s', must be one of %s", h.Source,
+			strings.Join([]string{SOURCE_FILE, SOURCE_JOURNAL, SOURCE_DOCKER, SOURCE_COMMAND}, ", "))
 	}
 	if h.Port == 0 {
 		h.Port = DEFAULT_SSH_PORT
 	}
+	if _, err := parseJumpHosts(h.Jump.String()); err != nil {
+		return fmt.Errorf("Host spec has an invalid jump: %v", err)
+	}
+	if err := h.Timestamp.Validate(); err != nil {
+		return err
+	}
+	if err := h.Filters.Validate(); err != nil {
+		return fmt.Errorf("Host spec has an invalid filter: %v", err)
+	}
+	if err := h.RemoteFilters.Validate(); err != nil {
+		return fmt.Errorf("Host spec has an invalid remote filter: %v", err)
+	}
+	if h.Backfill < 0 {
+		return errors.New("Host spec cannot have a negative backfill")
+	}
+	if h.Timeout < 0 {
+		return errors.New("Host spec cannot have a negative timeout")
+	}
+	if h.Timeout == 0 {
+		h.Timeout = DEFAULT_CONNECT_TIMEOUT
+	}
+	if err := h.Level.Validate(); err != nil {
+		return err
+	}
+	if h.Color != "" {
+		if _, err := parseColor(h.Color); err != nil {
+			return fmt.Errorf("Host spec has an invalid color: %v", err)
+		}
+	}
+	switch h.Mode {
+	case "":
+		h.Mode = MODE_TAIL
+	case MODE_TAIL, MODE_SFTP:
+	default:
+		return fmt.Errorf("Host spec has unknown mode '%s', must be '%s' or '%s'", h.Mode, MODE_TAIL, MODE_SFTP)
+	}
 	return nil
 }
 
-// KeySpec specifies the path to the SSH key to be used for the host named by the SpecData.Keys map key.
+// KeySpec specifies the path to the SSH key to be used for the host named by the SpecData.Keys map key. If Agent is
+// set, then the keys held by the ssh-agent listening on SSH_AUTH_SOCK are used instead.
 type KeySpec struct {
-	Path string `json:"path" yaml:"path"`
+	Path  string `json:"path" yaml:"path"`
+	Agent bool   `json:"agent" yaml:"agent"`
+	// fallback is set when no key was configured, in which case the agent is tried as well as the default key path.
+	fallback bool
 }
 
 // Validate checks the KeySpec for errors and sets reasonable defaults.
 func (k *KeySpec) Validate() error {
-	if k.Path == "" {
-		k.Path = DefaultSSHKeyPath()
+	if k.Path == "" && !k.Agent {
+		if c, err := ConfigFile(); err == nil && c != nil && c.DefaultKey != (KeySpec{}) {
+			*k = c.DefaultKey
+		} else {
+			k.Path = defaultSSHKeyPath()
+			k.fallback = true
+		}
 	}
 	return nil
 }
 
+// Reconnection defaults
+const (
+	DEFAULT_MAX_RETRIES     int           = 10
+	DEFAULT_INITIAL_BACKOFF time.Duration = time.Second
+	DEFAULT_MAX_BACKOFF     time.Duration = time.Minute
+)
+
+// ReconnectSpec controls how a lost connection to a host is reestablished. A negative MaxRetries retries forever.
+type ReconnectSpec struct {
+	MaxRetries     int           `json:"maxRetries" yaml:"maxRetries"`
+	InitialBackoff time.Duration `json:"initialBackoff" yaml:"initialBackoff"`
+	MaxBackoff     time.Duration `json:"maxBackoff" yaml:"maxBackoff"`
+}
+
+// Validate checks the ReconnectSpec for errors and sets reasonable defaults.
+func (r *ReconnectSpec) Validate() error {
+	if r.InitialBackoff < 0 || r.MaxBackoff < 0 {
+		return errors.New("Reconnect backoff cannot be negative")
+	}
+	if r.MaxRetries == 0 {
+		r.MaxRetries = DEFAULT_MAX_RETRIES
+	}
+	if r.InitialBackoff == 0 {
+		r.InitialBackoff = DEFAULT_INITIAL_BACKOFF
+	}
+	if r.MaxBackoff == 0 {
+		r.MaxBackoff = DEFAULT_MAX_BACKOFF
+	}
+	if r.MaxBackoff < r.InitialBackoff {
+		r.MaxBackoff = r.InitialBackoff
+	}
+	return nil
+}
+
+// Keepalive defaults
+const (
+	DEFAULT_KEEPALIVE_INTERVAL   time.Duration = 15 * time.Second
+	DEFAULT_KEEPALIVE_MAX_MISSED int           = 3
+)
+
+// KeepaliveSpec controls how often each connection is checked by sending a keepalive request, and how many requests in
+// a row may go unanswered before the connection is considered dead. A negative Interval turns keepalives off.
+type KeepaliveSpec struct {
+	Interval  time.Duration `json:"interval" yaml:"interval"`
+	MaxMissed int           `json:"maxMissed" yaml:"maxMissed"`
+}
+
+// Validate checks the KeepaliveSpec for errors and sets reasonable defaults.
+func (k *KeepaliveSpec) Validate() error {
+	if k.MaxMissed < 0 {
+		return errors.New("Keepalive max missed cannot be negative")
+	}
+	if k.Interval == 0 {
+		k.Interval = DEFAULT_KEEPALIVE_INTERVAL
+	}
+	if k.MaxMissed == 0 {
+		k.MaxMissed = DEFAULT_KEEPALIVE_MAX_MISSED
+	}
+	return nil
+}
+
+// TimestampSpec describes how to find the time a line was logged, which is used to order lines from different hosts.
+// Pattern is a regular expression matching the timestamp in the line, and if it has a group then only the first group
+// is parsed. Layout is the format of the timestamp, written like Go's reference time. Both default to matching an
+// RFC 3339 or ISO 8601 style timestamp.
+type TimestampSpec struct {
+	Layout  string `json:"layout" yaml:"layout"`
+	Pattern string `json:"pattern" yaml:"pattern"`
+	pattern *regexp.Regexp
+	layouts []string
+}
+
+// Validate checks the TimestampSpec for errors and sets reasonable defaults.
+func (t *TimestampSpec) Validate() error {
+	pattern := t.Pattern
+	if pattern == "" {
+		pattern = DEFAULT_TIMESTAMP_PATTERN
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("Host spec has an invalid timestamp pattern: %v", err)
+	}
+	t.pattern = re
+	if t.Layout == "" {
+		t.layouts = defaultTimestampLayouts
+	} else {
+		t.layouts = []string{t.Layout}
+	}
+	return nil
+}
+
+// FilterSpec selects which lines are written to the output using regular expressions. If there are any Include
+// patterns, then a line must match at least one of them. Lines matching any of the Exclude patterns are dropped.
+type FilterSpec struct {
+	Include []string `json:"include" yaml:"include"`
+	Exclude []string `json:"exclude" yaml:"exclude"`
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+// Validate checks that the FilterSpec's patterns are valid regular expressions.
+func (f *FilterSpec) Validate() error {
+	var err error
+	if f.include, err = compilePatterns(f.Include); err != nil {
+		return err
+	}
+	if f.exclude, err = compilePatterns(f.Exclude); err != nil {
+		return err
+	}
+	return nil
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := []*regexp.Regexp{}
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// LevelSpec describes how to find the log level of a host's lines, when it isn't in a common format. Pattern is a
+// regular expression matching the level, and if it has a group then only the first group is used. Field is the field
+// holding the level in JSON lines. Names maps the host's own level names, like 'E' or 'W', to standard level names.
+type LevelSpec struct {
+	Pattern string            `json:"pattern" yaml:"pattern"`
+	Field   string            `json:"field" yaml:"field"`
+	Names   map[string]string `json:"names" yaml:"names"`
+	pattern *regexp.Regexp
+	names   map[string]Level
+}
+
+// Validate checks the LevelSpec's pattern and level names.
+func (l *LevelSpec) Validate() error {
+	l.names = map[string]Level{}
+	for name, level := range l.Names {
+		parsed, err := ParseLevel(level)
+		if err != nil {
+			return fmt.Errorf("Host spec has an invalid level name '%s': %v", name, err)
+		}
+		l.names[name] = parsed
+	}
+	if l.Pattern == "" {
+		return nil
+	}
+	re, err := regexp.Compile(l.Pattern)
+	if err != nil {
+		return fmt.Errorf("Host spec has an invalid level pattern: %v", err)
+	}
+	l.pattern = re
+	return nil
+}
+
 // SpecData encapsulates runtime parameters for SSH tailing.
 type SpecData struct {
-	Hosts map[string]*HostSpec `json:"hosts" yaml:"hosts"`
-	Keys  map[string]*KeySpec  `json:"keys" yaml:"keys"`
+	Hosts     map[string]*HostSpec `json:"hosts" yaml:"hosts"`
+	Keys      map[string]*KeySpec  `json:"keys" yaml:"keys"`
+	Reconnect ReconnectSpec        `json:"reconnect" yaml:"reconnect"`
+	Keepalive KeepaliveSpec        `json:"keepalive" yaml:"keepalive"`
+	// MinHosts is how many hosts must be reachable when tailing starts. If it's set, then hosts that can't be reached
+	// don't stop the rest from being tailed.
+	MinHosts int `json:"minHosts" yaml:"minHosts"`
 }
 
 // Validate checks the SpecData for errors and sets reasonable defaults.
@@ -101,6 +451,16 @@ func (s *SpecData) Validate() error {
 		s.Keys = map[string]*KeySpec{}
 	}
 
+	if err := s.Reconnect.Validate(); err != nil {
+		return err
+	}
+	if err := s.Keepalive.Validate(); err != nil {
+		return err
+	}
+	if s.MinHosts < 0 || s.MinHosts > len(s.Hosts) {
+		return fmt.Errorf("Minimum hosts must be between 0 and the number of hosts, %d", len(s.Hosts))
+	}
+
 	hostsLen := len(s.Hosts)
 	keysLen := len(s.Keys)
 
@@ -115,7 +475,9 @@ func (s *SpecData) Validate() error {
 		}
 		_, found := s.Keys[k]
 		if !found {
-			s.Keys[k] = &KeySpec{DefaultSSHKeyPath()}
+			key := &KeySpec{}
+			key.Validate()
+			s.Keys[k] = key
 		}
 	}
 
@@ -137,7 +499,7 @@ func DefaultSSHKeyPath() string {
 	var ks KeySpec
 	c, err := ConfigFile()
 	if err != nil || c == nil || c.DefaultKey == ks {
-		ks = KeySpec{defaultSSHKeyPath()}
+		ks = KeySpec{Path: defaultSSHKeyPath()}
 	} else {
 		ks = c.DefaultKey
 	}
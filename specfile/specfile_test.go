@@ -23,13 +23,13 @@ import (
 )
 
 var hostAndKeysData SpecData = SpecData{
-	map[string]*HostSpec{
-		"host1": &HostSpec{"remote-host-1", "", "/var/log/syslog", 22},
-		"host2": &HostSpec{"remote-host-2", "me", "/var/log/syslog", 22},
+	Hosts: map[string]*HostSpec{
+		"host1": &HostSpec{Hostname: "remote-host-1", File: "/var/log/syslog", Port: 22},
+		"host2": &HostSpec{Hostname: "remote-host-2", Username: "me", File: "/var/log/syslog", Port: 22},
 	},
-	map[string]*KeySpec{
-		"host1": &KeySpec{"~/.ssh/id_rsa"},
-		"host2": &KeySpec{"~/.ssh/id_rsa"},
+	Keys: map[string]*KeySpec{
+		"host1": &KeySpec{Path: "~/.ssh/id_rsa"},
+		"host2": &KeySpec{Path: "~/.ssh/id_rsa"},
 	},
 }
 
@@ -51,13 +51,13 @@ keys:
 `
 
 var commentHostAndKeysData SpecData = SpecData{
-	map[string]*HostSpec{
-		"host1": &HostSpec{"remote-host-1", "", "/var/log/syslog", 22},
-		"host2": &HostSpec{"remote-host-2", "me", "/var/log/syslog", 22},
+	Hosts: map[string]*HostSpec{
+		"host1": &HostSpec{Hostname: "remote-host-1", File: "/var/log/syslog", Port: 22},
+		"host2": &HostSpec{Hostname: "remote-host-2", Username: "me", File: "/var/log/syslog", Port: 22},
 	},
-	map[string]*KeySpec{
-		"host1": &KeySpec{"~/.ssh/id_rsa"},
-		"host2": &KeySpec{"~/.ssh/id_rsa"},
+	Keys: map[string]*KeySpec{
+		"host1": &KeySpec{Path: "~/.ssh/id_rsa"},
+		"host2": &KeySpec{Path: "~/.ssh/id_rsa"},
 	},
 }
 
@@ -85,11 +85,10 @@ keys:
 `
 
 var hostData SpecData = SpecData{
-	map[string]*HostSpec{
-		"host1": &HostSpec{"remote-host-1", "", "/var/log/syslog", 22},
-		"host2": &HostSpec{"remote-host-2", "me", "/var/log/syslog", 22},
+	Hosts: map[string]*HostSpec{
+		"host1": &HostSpec{Hostname: "remote-host-1", File: "/var/log/syslog", Port: 22},
+		"host2": &HostSpec{Hostname: "remote-host-2", Username: "me", File: "/var/log/syslog", Port: 22},
 	},
-	nil,
 }
 
 const noKeysSpecText string = `hosts:
@@ -230,8 +229,11 @@ func TestReadHostOnly(t *testing.T) {
 
 func TestValidateHost(t *testing.T) {
 	errorList := []HostSpec{
-		HostSpec{"", "me", "file", 22}, // No host
-		HostSpec{"host", "me", "", 22}, // No file
+		HostSpec{Username: "me", File: "file", Port: 22},                        // No host
+		HostSpec{Hostname: "host", Username: "me", Port: 22},                    // No file
+		HostSpec{Hostname: "host", File: "file", Port: 22, Mode: "telnet"},      // Unknown mode
+		HostSpec{Hostname: "host", Files: []string{"file", ""}, Port: 22},       // Blank file entry
+		HostSpec{Hostname: "host", File: "file", Jump: JumpSpec{"bastion:ssh"}}, // Invalid jump port
 	}
 
 	for i, h := range errorList {
@@ -242,9 +244,24 @@ func TestValidateHost(t *testing.T) {
 	}
 }
 
+func TestMinHosts(t *testing.T) {
+	for _, min := range []int{-1, 3} {
+		spec := SpecData{
+			Hosts: map[string]*HostSpec{
+				"host1": &HostSpec{Hostname: "remote-host-1", File: "/var/log/syslog"},
+				"host2": &HostSpec{Hostname: "remote-host-2", File: "/var/log/syslog"},
+			},
+			MinHosts: min,
+		}
+		if err := spec.Validate(); err == nil {
+			t.Errorf("Minimum hosts of %d should not have passed validation", min)
+		}
+	}
+}
+
 func TestValueDefaultHost(t *testing.T) {
-	missingUser := HostSpec{"host", "", "file", 22}
-	missingPort := HostSpec{"host", "me", "file", 0}
+	missingUser := HostSpec{Hostname: "host", File: "file", Port: 22}
+	missingPort := HostSpec{Hostname: "host", Username: "me", File: "file"}
 	var err error
 
 	err = missingUser.Validate()
@@ -264,12 +281,86 @@ func TestValueDefaultHost(t *testing.T) {
 	}
 }
 
+func TestMultipleFiles(t *testing.T) {
+	const multiFileSpecText string = `hosts:
+  host1:
+    hostname: remote-host-1
+    file: /var/log/syslog
+    files:
+      - /var/log/nginx/error.log
+      - /var/log/syslog
+  host2:
+    hostname: remote-host-2
+    files:
+      - /var/log/nginx/access.log
+`
+	ioutil.WriteFile("testMultiFile.yml", []byte(multiFileSpecText), 0644)
+	defer os.Remove("testMultiFile.yml")
+	data, err := ReadSpecFile("testMultiFile.yml")
+	if err != nil {
+		t.Fatalf("Unable to read from file: %v", err)
+	}
+	if err = data.Validate(); err != nil {
+		t.Fatalf("Spec data didn't validate: %v", err)
+	}
+
+	got := data.Hosts["host1"].AllFiles()
+	want := []string{"/var/log/syslog", "/var/log/nginx/error.log"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("Got:\n%v\nWanted:\n%v", got, want)
+	}
+	got = data.Hosts["host2"].AllFiles()
+	if len(got) != 1 || got[0] != "/var/log/nginx/access.log" {
+		t.Errorf("Got:\n%v\nWanted only the access log", got)
+	}
+}
+
+func TestJumpSpec(t *testing.T) {
+	const jumpSpecText string = `hosts:
+  single:
+    hostname: remote-host-1
+    file: /var/log/syslog
+    jump: admin@bastion:2022,inner
+  chain:
+    hostname: remote-host-2
+    file: /var/log/syslog
+    jump:
+      - admin@bastion:2022
+      - inner
+`
+	ioutil.WriteFile("testJump.yml", []byte(jumpSpecText), 0644)
+	defer os.Remove("testJump.yml")
+	data, err := ReadSpecFile("testJump.yml")
+	if err != nil {
+		t.Fatalf("Unable to read from file: %v", err)
+	}
+	if err = data.Validate(); err != nil {
+		t.Fatalf("Spec data didn't validate: %v", err)
+	}
+
+	want := "admin@bastion:2022,inner"
+	for _, tag := range []string{"single", "chain"} {
+		if got := data.Hosts[tag].Jump.String(); got != want {
+			t.Errorf("Got:\n%s\nWanted:\n%s", got, want)
+		}
+	}
+}
+
+func TestFileTag(t *testing.T) {
+	if got := fileTag("host1", "/var/log/syslog", false); got != "host1" {
+		t.Errorf("Single file should only be tagged with the host, got '%s'", got)
+	}
+	if got := fileTag("host1", "/var/log/syslog", true); got != "host1:/var/log/syslog" {
+		t.Errorf("Multiple files should be tagged with host and file, got '%s'", got)
+	}
+}
+
 func TestDefaultKeysAdded(t *testing.T) {
 	spec := SpecData{
 		Hosts: map[string]*HostSpec{
-			"host1": &HostSpec{"host", "me", "file", 22},
-			"host2": &HostSpec{"host", "me", "file", 22},
-			"host3": &HostSpec{"host", "me", "file", 22},
+			"host1": &HostSpec{Hostname: "host", Username: "me", File: "file", Port: 22},
+			"host2": &HostSpec{Hostname: "host", Username: "me", File: "file", Port: 22},
+			"host3": &HostSpec{Hostname: "host", Username: "me", File: "file", Port: 22},
 		},
 		Keys: nil,
 	}
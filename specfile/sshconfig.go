@@ -0,0 +1,157 @@
+/*
+Copyright © 2020 Joseph Saylor <doug@saylorsolutions.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package specfile
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/kevinburke/ssh_config"
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+// sshConfig resolves host aliases using an OpenSSH client config file.
+type sshConfig struct {
+	cfg *ssh_config.Config
+}
+
+// loadSSHConfig reads the OpenSSH client config at the path. If the path is blank, then ~/.ssh/config is used if it
+// exists.
+func loadSSHConfig(filename string) (*sshConfig, error) {
+	if filename == "" {
+		u, _ := user.Current()
+		filename = path.Join(u.HomeDir, ".ssh", "config")
+		if _, err := os.Stat(filename); err != nil {
+			return &sshConfig{}, nil
+		}
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read SSH config '%s': %v", filename, err)
+	}
+	defer f.Close()
+	cfg, err := ssh_config.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse SSH config '%s': %v", filename, err)
+	}
+	return &sshConfig{cfg}, nil
+}
+
+// get returns the value of the key for the alias, or a blank string if it's not set.
+func (c *sshConfig) get(alias string, key string) (value string) {
+	if c == nil || c.cfg == nil {
+		return ""
+	}
+	defer func() {
+		// Match directives aren't supported by the parser, and it panics when it comes across one.
+		if r := recover(); r != nil {
+			value = ""
+		}
+	}()
+	value, _ = c.cfg.Get(alias, key)
+	return value
+}
+
+// sshHost is the connection information for a host alias resolved from the SSH config.
+type sshHost struct {
+	Hostname        string
+	User            string
+	Port            int
+	IdentityFile    string
+	KnownHostsFiles []string
+	ProxyJump       string
+}
+
+// resolve looks up the settings for the alias. Settings that aren't in the config are left blank.
+func (c *sshConfig) resolve(alias string) *sshHost {
+	h := &sshHost{
+		Hostname:  c.get(alias, "HostName"),
+		User:      c.get(alias, "User"),
+		ProxyJump: c.get(alias, "ProxyJump"),
+	}
+	if h.Hostname != "" {
+		h.Hostname = strings.ReplaceAll(h.Hostname, "%h", alias)
+	}
+	if port, err := strconv.Atoi(c.get(alias, "Port")); err == nil {
+		h.Port = port
+	}
+	if strings.EqualFold(h.ProxyJump, "none") {
+		h.ProxyJump = ""
+	}
+	if id := c.get(alias, "IdentityFile"); id != "" {
+		h.IdentityFile = expandSSHPath(id, alias, h)
+	}
+	for _, f := range strings.Fields(c.get(alias, "UserKnownHostsFile")) {
+		h.KnownHostsFiles = append(h.KnownHostsFiles, expandSSHPath(f, alias, h))
+	}
+	return h
+}
+
+// expandSSHPath expands the home directory and the tokens OpenSSH allows in file paths.
+func expandSSHPath(p string, alias string, h *sshHost) string {
+	home, _ := homedir.Dir()
+	hostname := h.Hostname
+	if hostname == "" {
+		hostname = alias
+	}
+	p = strings.NewReplacer("%d", home, "%h", hostname, "%n", alias, "%r", h.User, "%%", "%").Replace(p)
+	expanded, err := homedir.Expand(p)
+	if err != nil {
+		return p
+	}
+	return expanded
+}
+
+// jumpHost is one hop in a ProxyJump chain, written as [user@]host[:port].
+type jumpHost struct {
+	Alias string
+	User  string
+	Port  int
+}
+
+// parseJumpHosts parses a comma separated ProxyJump chain.
+func parseJumpHosts(chain string) ([]*jumpHost, error) {
+	hops := []*jumpHost{}
+	for _, hop := range strings.Split(chain, ",") {
+		hop = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(hop), "ssh://"))
+		if hop == "" {
+			continue
+		}
+		j := &jumpHost{}
+		if i := strings.LastIndex(hop, "@"); i >= 0 {
+			j.User = hop[:i]
+			hop = hop[i+1:]
+		}
+		if i := strings.LastIndex(hop, ":"); i >= 0 && !strings.HasSuffix(hop, "]") {
+			port, err := strconv.Atoi(hop[i+1:])
+			if err != nil {
+				return nil, fmt.Errorf("Invalid port in jump host '%s'", hop)
+			}
+			j.Port = port
+			hop = hop[:i]
+		}
+		j.Alias = strings.Trim(hop, "[]")
+		if j.Alias == "" {
+			return nil, fmt.Errorf("Jump host '%s' is missing a hostname", chain)
+		}
+		hops = append(hops, j)
+	}
+	return hops, nil
+}
@@ -0,0 +1,174 @@
+/*
+Copyright © 2020 Joseph Saylor <doug@saylorsolutions.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package specfile
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+const testSSHConfig = `
+Host web
+    HostName web.example.com
+    User deploy
+    Port 2200
+    IdentityFile /keys/%r-%n
+    UserKnownHostsFile /hosts/a /hosts/b
+    ProxyJump jump@bastion:2022
+
+Host direct
+    HostName %h.internal
+    ProxyJump none
+`
+
+func writeTestSSHConfig(t *testing.T) string {
+	f, err := ioutil.TempFile("", "sshtail-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err = f.WriteString(testSSHConfig); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func TestResolveSSHConfig(t *testing.T) {
+	filename := writeTestSSHConfig(t)
+	defer os.Remove(filename)
+	cfg, err := loadSSHConfig(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		alias string
+		want  *sshHost
+	}{
+		{"web", &sshHost{
+			Hostname:        "web.example.com",
+			User:            "deploy",
+			Port:            2200,
+			IdentityFile:    "/keys/deploy-web",
+			KnownHostsFiles: []string{"/hosts/a", "/hosts/b"},
+			ProxyJump:       "jump@bastion:2022",
+		}},
+		{"direct", &sshHost{Hostname: "direct.internal"}},
+		{"unknown", &sshHost{}},
+	}
+	for _, tt := range tests {
+		got := cfg.resolve(tt.alias)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Got:\n%+v\nWanted:\n%+v", got, tt.want)
+		}
+	}
+}
+
+func TestApplySSHConfig(t *testing.T) {
+	filename := writeTestSSHConfig(t)
+	defer os.Remove(filename)
+	cfg, err := loadSSHConfig(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	specData := &SpecData{
+		Hosts: map[string]*HostSpec{
+			"web":   {Hostname: "web", File: "/var/log/app.log"},
+			"mine":  {Hostname: "web", Username: "me", Port: 22, File: "/var/log/app.log"},
+			"plain": {Hostname: "plain.example.com", File: "/var/log/app.log"},
+		},
+		Keys: map[string]*KeySpec{
+			"mine": {Path: "/keys/mine"},
+		},
+	}
+	_, keys := applySSHConfig(specData, cfg)
+
+	if h := specData.Hosts["web"]; h.Username != "deploy" || h.Port != 2200 {
+		t.Errorf("Got:\n%s:%d\nWanted:\ndeploy:2200", h.Username, h.Port)
+	}
+	if k := keys["web"]; k == nil || k.Path != "/keys/deploy-web" {
+		t.Errorf("Got:\n%+v\nWanted:\n/keys/deploy-web", k)
+	}
+	if h := specData.Hosts["mine"]; h.Username != "me" || h.Port != 22 {
+		t.Errorf("Got:\n%s:%d\nWanted:\nme:22", h.Username, h.Port)
+	}
+	if k := specData.Keys["mine"]; k.Path != "/keys/mine" {
+		t.Errorf("Got:\n%s\nWanted:\n/keys/mine", k.Path)
+	}
+	if _, found := keys["plain"]; found {
+		t.Errorf("Got:\nkey for plain\nWanted:\nno key")
+	}
+	if _, found := keys["mine"]; found || len(specData.Keys) != 1 {
+		t.Errorf("Got:\n%v %v\nWanted:\nonly the spec's key for mine", keys, specData.Keys)
+	}
+}
+
+func TestApplySSHConfigNoKeys(t *testing.T) {
+	filename := writeTestSSHConfig(t)
+	defer os.Remove(filename)
+	cfg, err := loadSSHConfig(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	specData := &SpecData{
+		Hosts: map[string]*HostSpec{
+			"web":   {Hostname: "web", File: "/var/log/app.log"},
+			"plain": {Hostname: "plain.example.com", File: "/var/log/app.log"},
+		},
+	}
+	_, keys := applySSHConfig(specData, cfg)
+	if len(keys) != 1 || len(specData.Keys) != 0 {
+		t.Errorf("Got:\n%v %v\nWanted:\nonly an identity key for web", keys, specData.Keys)
+	}
+
+	// The keys from the SSH config don't count as keys from the spec, so there's no warning about the number of keys.
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	err = specData.Validate()
+	os.Stdout = stdout
+	w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := ioutil.ReadAll(r); len(out) != 0 {
+		t.Errorf("Got:\n%s\nWanted no output", out)
+	}
+}
+
+func TestParseJumpHosts(t *testing.T) {
+	got, err := parseJumpHosts("bastion, admin@gw.example.com:2022,ssh://[fe80::1]:22")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*jumpHost{
+		{Alias: "bastion"},
+		{Alias: "gw.example.com", User: "admin", Port: 2022},
+		{Alias: "fe80::1", Port: 22},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got:\n%+v\nWanted:\n%+v", got, want)
+	}
+
+	if _, err := parseJumpHosts("bastion:port"); err == nil {
+		t.Errorf("Got:\nnil\nWanted:\nerror")
+	}
+}
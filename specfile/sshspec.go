@@ -18,15 +18,20 @@ package specfile
 import (
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/signal"
 	"os/user"
 	"path"
+	"sort"
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/knownhosts"
 	"golang.org/x/crypto/ssh/terminal"
@@ -36,6 +41,19 @@ func noOpBanner(message string) error { return nil }
 
 // LoadKey reads a key from file
 func LoadKey(path string) (ssh.AuthMethod, error) {
+	signer, err := loadSigner(path, promptPassphrase)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.PublicKeys(signer), nil
+}
+
+// loadSigner reads a private key from file. If the key requires a passphrase, then it's read with passphrase if that's
+// set, otherwise an *ssh.PassphraseMissingError is returned.
+func loadSigner(path string, passphrase func(path string) ([]byte, error)) (ssh.Signer, error) {
+	if expanded, err := homedir.Expand(path); err == nil {
+		path = expanded
+	}
 	key, err := ioutil.ReadFile(path)
 	if err != nil {
 		return nil, err
@@ -43,12 +61,10 @@ func LoadKey(path string) (ssh.AuthMethod, error) {
 	signer, err := ssh.ParsePrivateKey(key)
 	if err != nil {
 		_, ok := err.(*ssh.PassphraseMissingError)
-		if ok {
-			fmt.Printf("Key %s requires a passphrase\n", path)
-			fmt.Printf("Enter passphrase: ")
-			passwd, err := terminal.ReadPassword(int(syscall.Stdin))
+		if ok && passphrase != nil {
+			passwd, err := passphrase(path)
 			if err != nil {
-				return nil, fmt.Errorf("Failed to read password: %v", err)
+				return nil, err
 			}
 			signer, err = ssh.ParsePrivateKeyWithPassphrase(key, passwd)
 			if err != nil {
@@ -60,139 +76,657 @@ func LoadKey(path string) (ssh.AuthMethod, error) {
 			return nil, err
 		}
 	}
-	return ssh.PublicKeys(signer), nil
+	return signer, nil
+}
+
+// promptPassphrase asks for the passphrase of the key at the path on the terminal.
+func promptPassphrase(path string) ([]byte, error) {
+	fmt.Printf("Key %s requires a passphrase\n", path)
+	fmt.Printf("Enter passphrase: ")
+	passwd, err := terminal.ReadPassword(int(syscall.Stdin))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read password: %v", err)
+	}
+	return passwd, nil
 }
 
-func createKnownHostsCallback() (ssh.HostKeyCallback, error) {
-	c, _ := user.Current()
-	knownHostPath := path.Join(c.HomeDir, ".ssh", "known_hosts")
-	knownHostsCallback, err := knownhosts.New(knownHostPath)
+// createKnownHostsCallback verifies host keys using the known hosts files, or ~/.ssh/known_hosts if none are given.
+func createKnownHostsCallback(files ...string) (ssh.HostKeyCallback, error) {
+	if len(files) == 0 {
+		c, _ := user.Current()
+		files = []string{path.Join(c.HomeDir, ".ssh", "known_hosts")}
+	}
+	knownHostsCallback, err := knownhosts.New(files...)
 	if err != nil {
-		return nil, fmt.Errorf("Unable to create host key verification callback using '%s': %v", knownHostPath, err)
+		return nil, fmt.Errorf("Unable to create host key verification callback using '%s': %v", strings.Join(files, "', '"), err)
 	}
 	return knownHostsCallback, nil
 }
 
-// ClientFilePair associates a Client connection with a host tag and file
+// Connection defaults
+const (
+	DEFAULT_CONNECT_TIMEOUT     time.Duration = 30 * time.Second
+	DEFAULT_CONNECT_CONCURRENCY int           = 16
+)
+
+// ClientOptions controls how connections to the hosts in a spec are established.
+type ClientOptions struct {
+	// AllowPartial lets tailing go ahead when some hosts can't be reached, like the spec's MinHosts does. Unless MinHosts
+	// says otherwise, one host is enough.
+	AllowPartial bool
+	// ConnectTimeout overrides the timeout of every host in the spec unless it's zero.
+	ConnectTimeout time.Duration
+	// Concurrency is how many hosts are connected to at once, which is DEFAULT_CONNECT_CONCURRENCY if it's zero.
+	Concurrency int
+	// SSHConfig is the OpenSSH client config used to resolve host aliases. If it's blank, then ~/.ssh/config is used
+	// if it exists.
+	SSHConfig string
+}
+
+// ClientFilePair associates a host connection with a host tag and file, as well as how the file should be followed.
+// Pairs for files on the same host share the same connection. Tag is used to identify the file's output, and is the host
+// tag unless the host has multiple files. If File is a glob pattern, then the pair is used as a template for the
+// files matching it. FromStart indicates that the whole file should be followed, rather than only new content. Pairs
+// for docker hosts follow a Container instead of a file, and a pair without one is the template for the containers
+// matching the host's labels.
 type ClientFilePair struct {
-	Client  *ssh.Client
-	HostTag string
-	File    string
+	host      *hostClient
+	HostTag   string
+	File      string
+	Mode      string
+	Tag       string
+	Spec      *HostSpec
+	FromStart bool
+	Container string
 }
 
-// setupClients validates the spec data and sets up ClientFilePair instances.
-func setupClients(specData *SpecData) ([]*ClientFilePair, error) {
-	var err error
-	clientPairs := make([]*ClientFilePair, len(specData.Hosts))
-	err = specData.Validate()
+// origin describes what the pair follows in status messages.
+func (p *ClientFilePair) origin() string {
+	switch {
+	case p.Spec.Source == SOURCE_JOURNAL:
+		return "the journal"
+	case p.Spec.Source == SOURCE_DOCKER && p.Container == "":
+		return "containers labeled " + strings.Join(p.Spec.Docker.Label, ", ")
+	case p.Spec.Source == SOURCE_DOCKER:
+		return "container " + p.Container
+	case p.Spec.Source == SOURCE_COMMAND:
+		return "the command"
+	}
+	return p.File
+}
+
+// filteredRemotely reports whether the pair's remote filters are applied on the host. Otherwise they're applied as
+// lines are received.
+func (p *ClientFilePair) filteredRemotely() bool {
+	return (p.Spec.followsFiles() || p.Spec.Source == SOURCE_COMMAND) && p.Mode != MODE_SFTP
+}
+
+// watched reports whether the pair is a template for the files or containers found by expanding it.
+func (p *ClientFilePair) watched() bool {
+	if p.Spec.Source == SOURCE_DOCKER {
+		return p.Container == ""
+	}
+	return isGlob(p.File)
+}
+
+// match creates the pair for a file or container found by expanding the pair.
+func (p *ClientFilePair) match(found string, fromStart bool) *ClientFilePair {
+	if p.Spec.Source == SOURCE_DOCKER {
+		return &ClientFilePair{p.host, p.HostTag, "", p.Mode, fileTag(p.HostTag, found, true), p.Spec, fromStart, found}
+	}
+	return &ClientFilePair{p.host, p.HostTag, found, p.Mode, fileTag(p.HostTag, found, true), p.Spec, fromStart, ""}
+}
+
+// fileTag creates the output tag for a file on a host, which only includes the file if the host may have more than one.
+func fileTag(hostTag string, file string, multiple bool) string {
+	if !multiple {
+		return hostTag
+	}
+	return fmt.Sprintf("%s:%s", hostTag, file)
+}
+
+// applySSHConfig fills in settings missing from the spec with those from the SSH config for each host's hostname. The
+// keys for hosts that don't have one in the spec but have an identity file are returned separately, so they aren't
+// counted as keys from the spec when it's validated.
+func applySSHConfig(specData *SpecData, sshCfg *sshConfig) (map[string]*sshHost, map[string]*KeySpec) {
+	resolved := map[string]*sshHost{}
+	keys := map[string]*KeySpec{}
+	for k, v := range specData.Hosts {
+		h := sshCfg.resolve(v.Hostname)
+		resolved[k] = h
+		if v.Username == "" {
+			v.Username = h.User
+		}
+		if v.Port == 0 {
+			v.Port = h.Port
+		}
+		if _, found := specData.Keys[k]; !found && h.IdentityFile != "" {
+			keys[k] = &KeySpec{Path: h.IdentityFile}
+		}
+	}
+	return resolved, keys
+}
+
+// setupClients validates the spec data and sets up ClientFilePair instances. Host settings that aren't in the spec are
+// looked up in the SSH config. Connections to jump hosts are shared through the returned pool. Retrying says whether the
+// caller keeps trying to connect to hosts that can't be reached at first.
+func setupClients(specData *SpecData, opts *ClientOptions, retrying bool) (clientPairs []*ClientFilePair, pool *jumpPool, err error) {
+	if opts == nil {
+		opts = &ClientOptions{}
+	}
+	if opts.ConnectTimeout < 0 {
+		return nil, nil, errors.New("Connect timeout cannot be negative")
+	}
+	if opts.Concurrency < 0 {
+		return nil, nil, errors.New("Connection concurrency cannot be negative")
+	}
+	clientPairs = []*ClientFilePair{}
+	sshCfg, err := loadSSHConfig(opts.SSHConfig)
 	if err != nil {
-		return nil, fmt.Errorf("Invalid spec data: %v", err)
+		return nil, nil, err
 	}
-	i := 0
-	knownHostsCallback, err := createKnownHostsCallback()
+	resolved, identityKeys := applySSHConfig(specData, sshCfg)
+	err = specData.Validate()
 	if err != nil {
-		return nil, err
+		return nil, nil, fmt.Errorf("Invalid spec data: %v", err)
 	}
+	for k, key := range identityKeys {
+		specData.Keys[k] = key
+	}
+
+	knownHostsCallbacks := map[string]ssh.HostKeyCallback{}
+	hostKeyCallback := func(files []string) (ssh.HostKeyCallback, error) {
+		key := strings.Join(files, "\n")
+		if cb, found := knownHostsCallbacks[key]; found {
+			return cb, nil
+		}
+		cb, err := createKnownHostsCallback(files...)
+		if err != nil {
+			return nil, err
+		}
+		knownHostsCallbacks[key] = cb
+		return cb, nil
+	}
+	auth := &authLoader{}
+	jumps := newJumpPool(specData.Keepalive)
+	hosts := []*hostClient{}
+	defer func() {
+		// Nothing is left connected if tailing can't go ahead.
+		if err != nil {
+			for _, host := range hosts {
+				host.Close()
+			}
+			jumps.Close()
+		}
+	}()
 	for k, v := range specData.Hosts {
-		authMethod, err := LoadKey(specData.Keys[k].Path)
+		authMethods, err := auth.authMethods(specData.Keys[k])
 		if err != nil {
-			return nil, fmt.Errorf("Failed to load key from %s: %v", specData.Keys[k].Path, err)
+			return nil, nil, err
+		}
+		sshHost := resolved[k]
+		knownHostsCallback, err := hostKeyCallback(sshHost.KnownHostsFiles)
+		if err != nil {
+			return nil, nil, err
+		}
+		timeout := v.Timeout
+		if opts.ConnectTimeout > 0 {
+			timeout = opts.ConnectTimeout
 		}
 		config := &ssh.ClientConfig{
-			User: v.Username,
-			Auth: []ssh.AuthMethod{
-				authMethod,
-			},
+			User:            v.Username,
+			Auth:            authMethods,
 			BannerCallback:  noOpBanner,
 			HostKeyCallback: knownHostsCallback,
+			Timeout:         timeout,
 		}
 		config.SetDefaults()
-		hostPort := fmt.Sprintf("%s:%d", v.Hostname, v.Port)
-		client, err := ssh.Dial("tcp", hostPort, config)
+		hostname := v.Hostname
+		if sshHost.Hostname != "" {
+			hostname = sshHost.Hostname
+		}
+		target := &dialTarget{fmt.Sprintf("%s:%d", hostname, v.Port), config}
+
+		jumpTargets := []*dialTarget{}
+		chain := v.Jump.String()
+		if chain == "" {
+			chain = sshHost.ProxyJump
+		}
+		hops, err := parseJumpHosts(chain)
 		if err != nil {
-			return nil, fmt.Errorf("Failed to connect to %s: %v", hostPort, err)
+			return nil, nil, fmt.Errorf("Host spec %s: %v", k, err)
+		}
+		for _, hop := range hops {
+			jumpTarget, err := resolveJumpHost(hop, sshCfg, authMethods, timeout, hostKeyCallback)
+			if err != nil {
+				return nil, nil, fmt.Errorf("Host spec %s: %v", k, err)
+			}
+			jumpTargets = append(jumpTargets, jumpTarget)
+		}
+
+		host := newHostClient(k, target, jumpTargets, jumps, specData.Reconnect)
+		hosts = append(hosts, host)
+
+		switch v.Source {
+		case SOURCE_JOURNAL, SOURCE_COMMAND:
+			clientPairs = append(clientPairs, &ClientFilePair{host, k, "", v.Mode, k, v, false, ""})
+		case SOURCE_DOCKER:
+			// Output is always tagged with the container, since the containers with a label can change.
+			for _, name := range v.Docker.Container {
+				clientPairs = append(clientPairs, &ClientFilePair{host, k, "", v.Mode, fileTag(k, name, true), v, false, name})
+			}
+			if len(v.Docker.Label) > 0 {
+				clientPairs = append(clientPairs, &ClientFilePair{host, k, "", v.Mode, k, v, false, ""})
+			}
+		default:
+			files := v.AllFiles()
+			multiple := len(files) > 1 || isGlob(files[0])
+			for _, file := range files {
+				clientPairs = append(clientPairs, &ClientFilePair{host, k, file, v.Mode, fileTag(k, file, multiple), v, false, ""})
+			}
 		}
+	}
+
+	required := len(hosts)
+	if specData.MinHosts > 0 {
+		required = specData.MinHosts
+	} else if opts.AllowPartial {
+		required = 1
+	}
+	concurrency := opts.Concurrency
+	if concurrency == 0 {
+		concurrency = DEFAULT_CONNECT_CONCURRENCY
+	}
+	if err = connectHosts(hosts, required, concurrency, retrying); err != nil {
+		return nil, nil, err
+	}
+	return clientPairs, jumps, nil
+}
+
+// connectHosts makes the initial connection to each host, connecting to up to concurrency hosts at once. An error is
+// returned as soon as too many hosts have failed to connect for the required number to be reached. Otherwise, the hosts
+// that couldn't be reached are reported. If retrying is set, then the report says that their sessions keep trying to
+// connect in the background like they would after losing the connection.
+func connectHosts(hosts []*hostClient, required int, concurrency int, retrying bool) error {
+	type result struct {
+		host *hostClient
+		err  error
+	}
+	results := make(chan result, len(hosts))
+	slots := make(chan struct{}, concurrency)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for _, host := range hosts {
+			select {
+			case slots <- struct{}{}:
+			case <-stop:
+				return
+			}
+			go func(host *hostClient) {
+				err := host.connect()
+				<-slots
+				results <- result{host, err}
+			}(host)
+		}
+	}()
+
+	progress := newConnectProgress(len(hosts))
+	failed := map[string]error{}
+	for range hosts {
+		r := <-results
+		if r.err == nil {
+			progress.connected()
+			continue
+		}
+		err := fmt.Errorf("Failed to connect to %s: %v", r.host.target.addr, r.err)
+		if required >= len(hosts) {
+			progress.done()
+			return err
+		}
+		failed[r.host.HostTag] = err
+		progress.failed()
+		if len(hosts)-len(failed) < required {
+			progress.done()
+			return fmt.Errorf("Fewer than %d hosts can be reached: %v", required, err)
+		}
+	}
+	progress.done()
+	tags := []string{}
+	for tag := range failed {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	for _, tag := range tags {
+		if retrying {
+			statusf("[ %s ] %v, retrying in the background\n", tag, failed[tag])
+		} else {
+			statusf("[ %s ] %v\n", tag, failed[tag])
+		}
+	}
+	return nil
+}
+
+// connectProgress reports how many hosts have connected so far. On a terminal the count is updated in place as hosts
+// connect, otherwise only the final count is written.
+type connectProgress struct {
+	total int
+	ok    int
+	bad   int
+	live  bool
+}
+
+func newConnectProgress(total int) *connectProgress {
+	return &connectProgress{total: total, live: terminal.IsTerminal(int(os.Stderr.Fd()))}
+}
 
-		clientPairs[i] = &ClientFilePair{client, k, v.File}
-		i++
+func (p *connectProgress) connected() {
+	p.ok++
+	p.update()
+}
+
+func (p *connectProgress) failed() {
+	p.bad++
+	p.update()
+}
+
+func (p *connectProgress) update() {
+	if p.live {
+		statusf("\r%s", p)
 	}
-	return clientPairs, nil
 }
 
-type TailChannelWriter struct {
-	prefix string
-	ch     chan<- string
+func (p *connectProgress) done() {
+	if p.live {
+		statusf("\r%s\n", p)
+	} else {
+		statusf("%s\n", p)
+	}
 }
 
-func (t TailChannelWriter) Write(b []byte) (n int, err error) {
-	t.ch <- fmt.Sprintf("[ %s ] %s", t.prefix, string(b))
-	n = len(b)
-	return
+func (p *connectProgress) String() string {
+	s := fmt.Sprintf("Connected to %d of %d hosts", p.ok, p.total)
+	if p.bad > 0 {
+		s += fmt.Sprintf(", %d failed", p.bad)
+	}
+	return s
 }
 
-// TailSession represents
+// resolveJumpHost creates the dial target for a jump host, using the SSH config for anything not given in the hop.
+// Jump hosts are authenticated the same way as the host that's being jumped to, and have the same timeout.
+func resolveJumpHost(hop *jumpHost, sshCfg *sshConfig, auth []ssh.AuthMethod, timeout time.Duration, hostKeyCallback func([]string) (ssh.HostKeyCallback, error)) (*dialTarget, error) {
+	h := sshCfg.resolve(hop.Alias)
+	hostname, username, port := hop.Alias, hop.User, hop.Port
+	if h.Hostname != "" {
+		hostname = h.Hostname
+	}
+	if username == "" {
+		username = h.User
+	}
+	if username == "" {
+		username = defaultUsername()
+	}
+	if port == 0 {
+		port = h.Port
+	}
+	if port == 0 {
+		port = DEFAULT_SSH_PORT
+	}
+	knownHostsCallback, err := hostKeyCallback(h.KnownHostsFiles)
+	if err != nil {
+		return nil, err
+	}
+	config := &ssh.ClientConfig{
+		User:            username,
+		Auth:            auth,
+		BannerCallback:  noOpBanner,
+		HostKeyCallback: knownHostsCallback,
+		Timeout:         timeout,
+	}
+	config.SetDefaults()
+	return &dialTarget{fmt.Sprintf("%s:%d", hostname, port), config}, nil
+}
+
+// follower streams content appended to a remote file to a writer until it's closed. Anything the remote command
+// writes to stderr is written to errOut.
+type follower interface {
+	follow(out io.Writer, errOut io.Writer) error
+	Close() error
+}
+
+// shellQuote quotes s so that it's passed to a remote command as a single literal argument.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// tailFollower uses the remote 'tail' executable to follow a file.
+type tailFollower struct {
+	session   *ssh.Session
+	file      string
+	fromStart bool
+	filter    *FilterSpec
+	backfill  int
+	marker    string
+	since     int64
+}
+
+func (t *tailFollower) follow(out io.Writer, errOut io.Writer) error {
+	t.session.Stdout = out
+	t.session.Stderr = errOut
+	return t.session.Run(t.command())
+}
+
+// command creates the remote command that follows the file. To backfill, the size of the file is taken first, so the
+// last lines before that point, or everything from the since offset to it, are written, then the marker, then
+// everything written after it.
+func (t *tailFollower) command() string {
+	file := shellQuote(t.file)
+	grep := t.filter.grepPipeline()
+	if t.marker != "" {
+		history := fmt.Sprintf(`head -c "$s" %s%s | tail -n %d | awk 1`, file, grep, t.backfill)
+		if t.since >= 0 {
+			history = fmt.Sprintf(`tail -c +%d %s | head -c "$((s-%d))"%s | awk 1`, t.since+1, file, t.since, grep)
+		}
+		follow := t.filter.filterCommand(fmt.Sprintf("tail -c +$((s+1)) -f %s", file))
+		return fmt.Sprintf(`s=$(($(wc -c < %[1]s))) && { %[2]s; printf '%%s\n' %[3]s; %[4]s; }`,
+			file, history, shellQuote(t.marker), follow)
+	}
+	lines := "0"
+	if t.fromStart {
+		lines = "+1"
+	}
+	return t.filter.filterCommand(fmt.Sprintf("tail -n %s -f %s", lines, file))
+}
+
+func (t *tailFollower) Close() error {
+	return t.session.Close()
+}
+
+// newFollower creates a follower for the pair's file over the client. If prev is given, then the new follower picks up
+// where it left off as closely as the mode allows. If there's a backfill marker, then the follower writes the last
+// lines of the file, or the lines logged since the start time if there is one, followed by the marker before following
+// it.
+func newFollower(pair *ClientFilePair, client *ssh.Client, prev follower, marker string, since time.Time) (follower, error) {
+	if !pair.Spec.followsFiles() {
+		session, err := client.NewSession()
+		if err != nil {
+			return nil, fmt.Errorf("Error establishing session: %v", err)
+		}
+		// Like tail, only new lines are followed after reconnecting, except that commands are run again.
+		switch pair.Spec.Source {
+		case SOURCE_DOCKER:
+			return &dockerFollower{session, pair.Container, pair.FromStart && prev == nil, pair.Spec.Backfill, marker, since}, nil
+		case SOURCE_COMMAND:
+			return &commandFollower{session, pair.Spec.Command, &pair.Spec.RemoteFilters}, nil
+		}
+		return &journalFollower{session, &pair.Spec.Journal, pair.Spec.Backfill, marker, since}, nil
+	}
+	switch pair.Mode {
+	case MODE_SFTP:
+		sc, err := sftp.NewClient(client)
+		if err != nil {
+			return nil, fmt.Errorf("Error establishing SFTP session: %v", err)
+		}
+		f := newSFTPFollower(sc, pair.File)
+		f.fromStart = pair.FromStart
+		f.backfill = pair.Spec.Backfill
+		f.marker = marker
+		f.since = since
+		f.timestamps = &pair.Spec.Timestamp
+		f.tag = pair.Tag
+		if p, ok := prev.(*sftpFollower); ok {
+			f.offset = p.offset
+			f.fromStart = true
+		}
+		return f, nil
+	default:
+		session, err := client.NewSession()
+		if err != nil {
+			return nil, fmt.Errorf("Error establishing session: %v", err)
+		}
+		// There's no way to tell tail where it left off, so only new content is followed after reconnecting.
+		t := &tailFollower{session, pair.File, pair.FromStart && prev == nil, &pair.Spec.RemoteFilters, pair.Spec.Backfill, marker, -1}
+		if marker != "" && !since.IsZero() {
+			if offset, ok := tailSinceOffset(pair, client, since); ok {
+				t.since = offset
+			} else {
+				t.backfill = 0
+			}
+		}
+		return t, nil
+	}
+}
+
+// tailSinceOffset searches the file on the host for where to start writing lines logged since the start time. If the
+// search fails, then the whole file is written and the writer drops lines before the start time. If the file has no
+// timestamps to search for, then there's nothing to write, and false is returned.
+func tailSinceOffset(pair *ClientFilePair, client *ssh.Client, since time.Time) (int64, bool) {
+	r := &execReader{client, pair.File}
+	size, err := r.size()
+	if err == nil {
+		var offset int64
+		if offset, err = findSince(r, size, since, &pair.Spec.Timestamp); err == nil {
+			return offset, true
+		}
+	}
+	if err == errNoTimestamps {
+		statusf("[ %s ] can't find the lines logged since the start time, only following new lines: %v\n", pair.Tag, err)
+		return 0, false
+	}
+	statusf("[ %s ] failed to search for the start time, reading the whole file: %v\n", pair.Tag, err)
+	return 0, true
+}
+
+// TailSession represents a single file being followed on a remote host. The session is supervised so that it's
+// resumed if the connection to the host is lost. What the remote command writes to stderr is sent as diagnostic
+// events by the session's stderr writer. Once the session stops, why it did is recorded as its termination.
 type TailSession struct {
-	clientPair *ClientFilePair
-	session    *ssh.Session
-	closed     bool
-	started    bool
-	wg         *sync.WaitGroup
+	clientPair  *ClientFilePair
+	follower    follower
+	stderr      *TailChannelWriter
+	since       time.Time
+	termination *Termination
+	closed      bool
+	started     bool
+	wg          *sync.WaitGroup
+	mu          sync.Mutex
+	done        chan struct{}
 }
 
 // Closed returns whether the tail session has been previously closed. A closed tail session cannot be restarted.
 func (s *TailSession) Closed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	return s.closed
 }
 
 // Started returns whether the tail session has already been started.
 func (s *TailSession) Started() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	return s.started
 }
 
-// Close stops the running tail session and disconnects the client.
+// Termination returns why the session stopped, or nil if it hasn't.
+func (s *TailSession) Termination() *Termination {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.termination
+}
+
+// terminate records why the session stopped, unless it already stopped for another reason.
+func (s *TailSession) terminate(t *Termination) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.termination == nil {
+		s.termination = t
+	}
+}
+
+// Close stops the running tail session. The client is left open since it may be shared with other sessions.
 func (s *TailSession) Close() (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if !s.closed {
-		fmt.Printf("Closing session to %s\n", s.clientPair.HostTag)
+		statusf("Closing session to %s\n", s.clientPair.Tag)
 		s.closed = true
-		sb := strings.Builder{}
-		errorsOccurred := false
-		e1 := s.session.Close()
-		if e1 != nil {
-			sb.WriteString(e1.Error())
-			errorsOccurred = true
-		}
-		e2 := s.clientPair.Client.Close()
-		if e2 != nil {
-			sb.WriteString(e2.Error())
-			errorsOccurred = true
+		close(s.done)
+		if s.termination == nil {
+			s.termination = &Termination{Reason: TERM_CLOSED}
 		}
-		if errorsOccurred {
-			err = fmt.Errorf("Error(s) closing tail session: %s", sb.String())
+		if s.follower != nil {
+			// Sessions whose remote command already exited have nothing left to close.
+			e1 := s.follower.Close()
+			if e1 != nil && e1 != io.EOF {
+				err = fmt.Errorf("Error closing tail session: %v", e1)
+			}
 		}
-		s.wg.Done()
 	}
 	return
 }
 
+// setFollower replaces the session's follower, unless the session was closed in the meantime.
+func (s *TailSession) setFollower(f follower) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		f.Close()
+		return false
+	}
+	s.follower = f
+	return true
+}
+
 // Start the tail session using configured parameters
-func (s *TailSession) start(ch chan<- string, wg *sync.WaitGroup) error {
+func (s *TailSession) start(ch chan<- *Event, wg *sync.WaitGroup) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if !s.closed {
 		if s.started {
 			return errors.New("Tail session is already started")
 		}
-		session, err := s.clientPair.Client.NewSession()
-		if err != nil {
-			return fmt.Errorf("Error establishing session: %v", err)
-		}
-		s.session = session
-		session.Stdout = TailChannelWriter{s.clientPair.HostTag, ch}
-		go func() {
-			wg.Add(1)
-			s.wg = wg
-			cmd := fmt.Sprintf("tail -n 0 -f %s", s.clientPair.File)
-			session.Run(cmd)
-			// I don't care that tail will exit ungracefully, not handling or reporting error
-		}()
+		// Files that are followed from the start have nothing to backfill, and neither do commands.
+		marker := ""
+		if (s.clientPair.Spec.Backfill > 0 || !s.since.IsZero()) && !s.clientPair.FromStart && s.clientPair.Spec.hasHistory() {
+			marker = newBackfillMarker()
+		}
+		// If the host couldn't be reached, then the session is started once it's connected in the background.
+		client := s.clientPair.host.Client()
+		var f follower
+		if client != nil {
+			var err error
+			if f, err = newFollower(s.clientPair, client, nil, marker, s.since); err != nil {
+				s.termination = &Termination{Reason: TERM_ERROR, Err: err}
+				return err
+			}
+		}
+		s.follower = f
+		out := NewTailChannelWriter(s.clientPair, ch)
+		out.backfillUntil(marker, s.since)
+		s.stderr = newStderrWriter(s.clientPair, ch)
+		wg.Add(1)
+		s.wg = wg
+		go s.run(f, client, out, marker)
 		s.started = true
 	} else {
 		return errors.New("Can't start a closed tail session")
@@ -200,41 +734,146 @@ func (s *TailSession) start(ch chan<- string, wg *sync.WaitGroup) error {
 	return nil
 }
 
+// run follows the file until the session is closed. If the connection to the host is lost, then it's reestablished
+// with exponential backoff and the file is followed again. If there's no follower yet because the host couldn't be
+// reached, then the host is connected the same way, and the file is followed as it would have been from the start
+// with the backfill marker.
+func (s *TailSession) run(f follower, client *ssh.Client, out *TailChannelWriter, marker string) {
+	defer s.wg.Done()
+	spec := s.clientPair.host.reconnect
+	var err error
+	for {
+		if f != nil {
+			err = f.follow(out, s.stderr)
+			out.Flush()
+			s.stderr.Flush()
+			if s.Closed() {
+				return
+			}
+			if probe(client, PROBE_TIMEOUT) == nil {
+				// The connection is fine, so whatever was following the file stopped on its own.
+				t := commandTermination(err)
+				s.terminate(t)
+				statusf("[ %s ] stopped following %s, it %s\n", s.clientPair.Tag, s.clientPair.origin(), t)
+				return
+			}
+		}
+
+		prev := f
+		verb := "reconnect"
+		if prev == nil {
+			verb = "connect"
+		}
+		f = nil
+		for attempt := 1; f == nil; attempt++ {
+			if spec.MaxRetries >= 0 && attempt > spec.MaxRetries {
+				statusf("[ %s ] giving up after %d %sion attempts\n", s.clientPair.Tag, spec.MaxRetries, verb)
+				s.terminate(&Termination{Reason: TERM_NETWORK, Err: err})
+				return
+			}
+			select {
+			case <-s.done:
+				return
+			case <-time.After(backoff(attempt, spec)):
+			}
+			statusf("[ %s ] %sing (attempt %d)\n", s.clientPair.Tag, verb, attempt)
+			client, err = s.clientPair.host.redial(client)
+			if err == nil && prev == nil {
+				f, err = newFollower(s.clientPair, client, nil, marker, s.since)
+			} else if err == nil {
+				f, err = newFollower(s.clientPair, client, prev, "", time.Time{})
+			}
+			if err != nil {
+				if err == errHostClosed {
+					s.terminate(&Termination{Reason: TERM_CLOSED})
+					return
+				}
+				statusf("[ %s ] %sion failed: %v\n", s.clientPair.Tag, verb, err)
+			}
+		}
+		if !s.setFollower(f) {
+			return
+		}
+		statusf("[ %s ] %sed\n", s.clientPair.Tag, verb)
+	}
+}
+
 // NewTailSession creates a new TailSession instance that is ready to be started.
 func NewTailSession(client *ClientFilePair) (ts *TailSession, err error) {
-	ts = &TailSession{client, nil, false, false, nil}
+	ts = &TailSession{clientPair: client, done: make(chan struct{})}
 	return
 }
 
 // ConsolidatedWriter receives messages from all of its tail session instances and writes them to its output stream.
 type ConsolidatedWriter struct {
-	ch          chan string
+	ch          chan *Event
 	sessions    []*TailSession
+	globs       []*ClientFilePair
+	hosts       []*hostClient
+	jumps       *jumpPool
 	out         *os.File
 	started     bool
 	closed      bool
 	outputFiles []*os.File
+	formatter   Formatter
+	colors      map[string]string
+	colored     bool
+	outFormat   Formatter
+	order       time.Duration
+	timestamps  map[string]*TimestampSpec
+	filter      *FilterSpec
+	filters     map[string]*FilterSpec
+	levels      map[string]*LevelSpec
+	minLevel    Level
+	since       time.Time
+	mu          sync.Mutex
+	wg          sync.WaitGroup
+	done        chan struct{}
 }
 
 // NewConsolidatedWriter creates tail sessions that are ready to start and write to the provided writer.
-func NewConsolidatedWriter(specData *SpecData, out *os.File) (*ConsolidatedWriter, error) {
-	clientPairs, err := setupClients(specData)
-	numHosts := len(specData.Hosts)
-	var ch chan string = make(chan string, numHosts)
-	var sessions []*TailSession = make([]*TailSession, numHosts)
+func NewConsolidatedWriter(specData *SpecData, out *os.File, opts *ClientOptions) (*ConsolidatedWriter, error) {
+	clientPairs, jumps, err := setupClients(specData, opts, true)
 	if err != nil {
 		return nil, err
 	}
+	c := &ConsolidatedWriter{
+		jumps:       jumps,
+		ch:          make(chan *Event, len(clientPairs)),
+		out:         out,
+		outputFiles: []*os.File{},
+		formatter:   textFormatter{},
+		timestamps:  map[string]*TimestampSpec{},
+		filters:     map[string]*FilterSpec{},
+		colors:      map[string]string{},
+		levels:      map[string]*LevelSpec{},
+		done:        make(chan struct{}),
+	}
+	for k, v := range specData.Hosts {
+		c.timestamps[k] = &v.Timestamp
+		c.filters[k] = &v.Filters
+		c.colors[k] = hostColor(k, v)
+		c.levels[k] = &v.Level
+	}
+	seen := map[*hostClient]bool{}
 
-	for i, pair := range clientPairs {
+	for _, pair := range clientPairs {
+		if !seen[pair.host] {
+			seen[pair.host] = true
+			c.hosts = append(c.hosts, pair.host)
+		}
+		if pair.watched() {
+			c.globs = append(c.globs, pair)
+			continue
+		}
 		ts, err := NewTailSession(pair)
 		if err != nil {
 			return nil, err
 		}
-		sessions[i] = ts
+		c.sessions = append(c.sessions, ts)
 	}
 
-	return &ConsolidatedWriter{ch, sessions, out, false, false, []*os.File{}}, nil
+	return c, nil
 }
 
 // AddOutputFile adds a file to the list of files that should have output appended to them.
@@ -247,57 +886,329 @@ func (c *ConsolidatedWriter) AddOutputFile(file *os.File) error {
 	return nil
 }
 
+// SetFormatter sets how events are formatted in the output. Text output is used by default.
+func (c *ConsolidatedWriter) SetFormatter(formatter Formatter) {
+	c.formatter = formatter
+}
+
+// SetColored colors each host's prefix in text output. Output files are never colored.
+func (c *ConsolidatedWriter) SetColored(colored bool) {
+	c.colored = colored
+}
+
+// addSession adds a tail session to the running writer and starts it.
+func (c *ConsolidatedWriter) addSession(ts *TailSession) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return errors.New("Can't add a session to a closed writer")
+	}
+	c.sessions = append(c.sessions, ts)
+	ts.since = c.since
+	return ts.start(c.ch, &c.wg)
+}
+
+// removeSession closes a session whose file or container is gone, and stops keeping track of it.
+func (c *ConsolidatedWriter) removeSession(ts *TailSession) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, s := range c.sessions {
+		if s == ts {
+			c.sessions = append(c.sessions[:i], c.sessions[i+1:]...)
+			break
+		}
+	}
+	if ts.Started() && !ts.Closed() {
+		if err := ts.Close(); err != nil {
+			statusf("[ %s ] %v\n", ts.clientPair.Tag, err)
+		}
+	}
+}
+
 // Close closes all tail sessions as well as the connected clients.
 func (c *ConsolidatedWriter) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		c.closed = true
+		close(c.done)
+	}
+	var err error
 	for _, ts := range c.sessions {
 		if ts.Started() && !ts.Closed() {
-			ts.Close()
+			if e1 := ts.Close(); e1 != nil {
+				statusf("[ %s ] %v\n", ts.clientPair.Tag, e1)
+				err = errors.New("Failed to close all tail sessions")
+			}
 		}
 	}
+	for _, host := range c.hosts {
+		host.Close()
+	}
+	if c.jumps != nil {
+		c.jumps.Close()
+	}
 	if len(c.outputFiles) > 0 {
 		for _, f := range c.outputFiles {
 			f.Close()
 		}
 	}
-	return nil
+	return err
+}
+
+// SetOrdered merges lines from all hosts in the order they were logged, rather than the order they arrived in. Each
+// line is held for the window to give lines from other hosts time to arrive. A window of zero turns ordering off.
+func (c *ConsolidatedWriter) SetOrdered(window time.Duration) {
+	c.order = window
+}
+
+// SetFilter applies the filter to lines from every host, in addition to each host's own filters.
+func (c *ConsolidatedWriter) SetFilter(filter *FilterSpec) {
+	c.filter = filter
+}
+
+// SetMinLevel hides lines with a detected log level below the minimum. Lines without a level are always written.
+func (c *ConsolidatedWriter) SetMinLevel(level Level) {
+	c.minLevel = level
+}
+
+// SetSince starts each file with the lines logged since the time, instead of only following new lines. Each host's
+// timestamp settings are used to find where that is in the file.
+func (c *ConsolidatedWriter) SetSince(since time.Time) {
+	c.since = since
+}
+
+// output writes events as they're received until stop is closed, then writes whatever is left and closes finished.
+// Lines are filtered before they're ordered, so they don't hold up lines that will be written.
+func (c *ConsolidatedWriter) output(stop <-chan struct{}, finished chan<- struct{}) {
+	defer close(finished)
+	lines := newFilterLines(c.filter, c.filters, c.minLevel)
+	defer lines.report()
+	// Levels are only needed to filter, highlight, or include them in structured output.
+	_, plain := c.formatter.(textFormatter)
+	detect := !plain || c.colored || c.minLevel > LEVEL_UNKNOWN
+	keep := func(e *Event) bool {
+		if detect && e.Level == LEVEL_UNKNOWN {
+			e.Level, e.levelStart, e.levelEnd = c.levels[e.Host].Detect(e.Line)
+		}
+		return lines.keep(e)
+	}
+	if c.order <= 0 {
+		write := func(e *Event) {
+			if keep(e) {
+				c.write(e)
+			}
+		}
+		for {
+			select {
+			case e := <-c.ch:
+				write(e)
+			case <-stop:
+				for {
+					select {
+					case e := <-c.ch:
+						write(e)
+					default:
+						return
+					}
+				}
+			}
+		}
+	}
+
+	buf := newOrderedBuffer(c.order, c.timestamps)
+	add := func(e *Event) {
+		if keep(e) {
+			buf.add(e)
+		}
+	}
+	for {
+		var tick <-chan time.Time
+		if wait, pending := buf.next(time.Now()); pending {
+			tick = time.After(wait)
+		}
+		select {
+		case e := <-c.ch:
+			add(e)
+		case <-tick:
+			for _, e := range buf.ready(time.Now()) {
+				c.write(e)
+			}
+		case <-stop:
+			for {
+				select {
+				case e := <-c.ch:
+					add(e)
+				default:
+					for _, e := range buf.flush() {
+						c.write(e)
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+// write formats the event and writes it to the output and all output files.
+func (c *ConsolidatedWriter) write(e *Event) {
+	c.out.WriteString(c.outFormat.Format(e))
+	if len(c.outputFiles) == 0 {
+		return
+	}
+	line := c.formatter.Format(e)
+	for _, o := range c.outputFiles {
+		_, err := o.WriteString(line)
+		if err != nil {
+			statusf("[ERROR] Failed to write line to '%s'\n", o.Name())
+		}
+	}
+}
+
+// reportStderr writes how many lines each session's remote command wrote to stderr and the last of them, so they
+// aren't lost in the rest of the output.
+func (c *ConsolidatedWriter) reportStderr() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, ts := range c.sessions {
+		if ts.stderr == nil {
+			continue
+		}
+		if n, last := ts.stderr.stderrSummary(); n > 0 {
+			statusf("[ %s ] wrote %d lines to stderr, the last was: %s\n", ts.clientPair.Tag, n, last)
+		}
+	}
+}
+
+// hostSessions groups the sessions by host, and returns the host tags in order.
+func (c *ConsolidatedWriter) hostSessions() ([]string, map[string][]*TailSession) {
+	hosts := map[string][]*TailSession{}
+	for _, host := range c.hosts {
+		hosts[host.HostTag] = nil
+	}
+	for _, ts := range c.sessions {
+		hosts[ts.clientPair.HostTag] = append(hosts[ts.clientPair.HostTag], ts)
+	}
+	tags := []string{}
+	for tag := range hosts {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags, hosts
+}
+
+// reportTerminations writes a summary of how each host's sessions ended. Hosts whose sessions all ended as expected
+// get a single line, otherwise each session that didn't is listed.
+func (c *ConsolidatedWriter) reportTerminations() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tags, hosts := c.hostSessions()
+	unreachable := c.unreachable()
+	for _, tag := range tags {
+		sessions := hosts[tag]
+		if err, found := unreachable[tag]; found {
+			if err != nil {
+				statusf("[ %s ] never connected: %v\n", tag, err)
+			} else {
+				statusf("[ %s ] never connected\n", tag)
+			}
+			continue
+		}
+		if len(sessions) == 0 {
+			statusf("[ %s ] nothing was followed\n", tag)
+			continue
+		}
+		failed := false
+		for _, ts := range sessions {
+			if t := ts.Termination(); t != nil && t.Unexpected() {
+				failed = true
+				statusf("[ %s ] %s\n", ts.clientPair.Tag, t)
+			}
+		}
+		if !failed {
+			statusf("[ %s ] ok\n", tag)
+		}
+	}
+}
+
+// unreachable returns the hosts that were never connected to, and why the last attempt to connect to each failed.
+func (c *ConsolidatedWriter) unreachable() map[string]error {
+	hosts := map[string]error{}
+	for _, host := range c.hosts {
+		if never, err := host.neverConnected(); never {
+			hosts[host.HostTag] = err
+		}
+	}
+	return hosts
+}
+
+// Failed returns the tags of the hosts that were never connected to, or that have sessions that stopped unexpectedly,
+// like when the remote command failed or the connection was lost for good.
+func (c *ConsolidatedWriter) Failed() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	failed := []string{}
+	tags, hosts := c.hostSessions()
+	unreachable := c.unreachable()
+	for _, tag := range tags {
+		if _, found := unreachable[tag]; found {
+			failed = append(failed, tag)
+			continue
+		}
+		for _, ts := range hosts[tag] {
+			if t := ts.Termination(); t != nil && t.Unexpected() {
+				failed = append(failed, tag)
+				break
+			}
+		}
+	}
+	return failed
 }
 
 // Start starts all tail sessions. In the event of an error, all already opened sessions are closed and an error is returned.
 func (c *ConsolidatedWriter) Start() error {
-	var wg sync.WaitGroup
+	c.mu.Lock()
 	for _, ts := range c.sessions {
 		if !ts.Started() && !ts.Closed() {
-			err := ts.start(c.ch, &wg)
+			ts.since = c.since
+			err := ts.start(c.ch, &c.wg)
 			if err != nil {
-				fmt.Println("Failed to start consolidated writer. Closing sessions.")
+				c.mu.Unlock()
+				statusf("Failed to start consolidated writer. Closing sessions.\n")
 				c.Close()
 				return err
 			}
 		}
 	}
+	c.started = true
+	for _, pattern := range c.globs {
+		c.wg.Add(1)
+		go c.watchGlob(pattern)
+	}
+	c.mu.Unlock()
 
-	fmt.Printf("Started tailing, send interrupt signal to exit\n\n")
-	go func() {
-		for line := range c.ch {
-			c.out.WriteString(line)
-			for _, o := range c.outputFiles {
-				_, err := o.WriteString(line)
-				if err != nil {
-					fmt.Printf("[ERROR] Failed to write line to '%s'\n", o.Name())
-				}
-			}
-		}
-	}()
+	statusf("Started tailing, send interrupt signal to exit\n\n")
+	c.outFormat = c.formatter
+	if _, ok := c.formatter.(textFormatter); ok && c.colored {
+		c.outFormat = textFormatter{colors: c.colors}
+	}
+	stop := make(chan struct{})
+	finished := make(chan struct{})
+	go c.output(stop, finished)
 
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
 		<-sigs
-		fmt.Println("\nSignal received, closing sessions")
+		statusf("\nSignal received, closing sessions\n")
 		c.Close()
 	}()
 
-	wg.Wait()
-	fmt.Println("Shut down complete")
+	c.wg.Wait()
+	close(stop)
+	<-finished
+	c.reportStderr()
+	c.reportTerminations()
+	statusf("Shut down complete\n")
 	return nil
 }
@@ -0,0 +1,89 @@
+/*
+Copyright © 2020 Joseph Saylor <doug@saylorsolutions.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package specfile
+
+import (
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Reasons that a TailSession stopped.
+const (
+	// TERM_CLOSED is a session that was closed, like when sshtail is interrupted.
+	TERM_CLOSED string = "closed"
+	// TERM_EXIT is a session whose remote command exited on its own.
+	TERM_EXIT string = "exit"
+	// TERM_SIGNAL is a session whose remote command was killed by a signal.
+	TERM_SIGNAL string = "signal"
+	// TERM_NETWORK is a session whose connection was lost and couldn't be reestablished.
+	TERM_NETWORK string = "network"
+	// TERM_ERROR is a session that failed for any other reason, like an SFTP error.
+	TERM_ERROR string = "error"
+)
+
+// Termination is why a TailSession stopped. ExitCode is set when the remote command exited, and Signal when it was
+// killed by a signal. Err is the error that stopped the session, if there was one.
+type Termination struct {
+	Reason   string
+	ExitCode int
+	Signal   string
+	Err      error
+}
+
+// commandTermination describes how a follower stopped when its connection is still up, based on the error it
+// returned.
+func commandTermination(err error) *Termination {
+	switch e := err.(type) {
+	case nil:
+		return &Termination{Reason: TERM_EXIT}
+	case *ssh.ExitError:
+		if e.Signal() != "" {
+			return &Termination{Reason: TERM_SIGNAL, ExitCode: e.ExitStatus(), Signal: e.Signal(), Err: err}
+		}
+		return &Termination{Reason: TERM_EXIT, ExitCode: e.ExitStatus(), Err: err}
+	}
+	return &Termination{Reason: TERM_ERROR, Err: err}
+}
+
+// Unexpected reports whether the session stopped for any reason other than being closed or its remote command
+// finishing successfully.
+func (t *Termination) Unexpected() bool {
+	switch t.Reason {
+	case TERM_CLOSED:
+		return false
+	case TERM_EXIT:
+		return t.ExitCode != 0
+	}
+	return true
+}
+
+func (t *Termination) String() string {
+	switch t.Reason {
+	case TERM_CLOSED:
+		return "closed"
+	case TERM_EXIT:
+		if t.ExitCode == 0 {
+			return "finished"
+		}
+		return fmt.Sprintf("exited with status %d", t.ExitCode)
+	case TERM_SIGNAL:
+		return fmt.Sprintf("killed by signal %s", t.Signal)
+	case TERM_NETWORK:
+		return fmt.Sprintf("lost the connection: %v", t.Err)
+	}
+	return fmt.Sprintf("failed: %v", t.Err)
+}
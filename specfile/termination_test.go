@@ -0,0 +1,76 @@
+/*
+Copyright © 2020 Joseph Saylor <doug@saylorsolutions.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package specfile
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTermination(t *testing.T) {
+	tests := []struct {
+		termination *Termination
+		want        string
+		unexpected  bool
+	}{
+		{&Termination{Reason: TERM_CLOSED}, "closed", false},
+		{commandTermination(nil), "finished", false},
+		{&Termination{Reason: TERM_EXIT, ExitCode: 1}, "exited with status 1", true},
+		{&Termination{Reason: TERM_SIGNAL, Signal: "KILL"}, "killed by signal KILL", true},
+		{&Termination{Reason: TERM_NETWORK, Err: errors.New("connection refused")}, "lost the connection: connection refused", true},
+		{commandTermination(errors.New("permission denied")), "failed: permission denied", true},
+	}
+	for _, tt := range tests {
+		if got := tt.termination.String(); got != tt.want {
+			t.Errorf("Got:\n%s\nWanted:\n%s", got, tt.want)
+		}
+		if got := tt.termination.Unexpected(); got != tt.unexpected {
+			t.Errorf("'%s' Got:\n%v\nWanted:\n%v", tt.want, got, tt.unexpected)
+		}
+	}
+}
+
+func TestSessionTermination(t *testing.T) {
+	ts, _ := NewTailSession(testPair)
+	if ts.Termination() != nil {
+		t.Errorf("Got:\n%v\nWanted:\nno termination while running", ts.Termination())
+	}
+	ts.Close()
+	if got := ts.Termination(); got == nil || got.Reason != TERM_CLOSED {
+		t.Errorf("Got:\n%v\nWanted:\n%s", got, TERM_CLOSED)
+	}
+
+	// Closing a session that already stopped doesn't hide why it did.
+	ts, _ = NewTailSession(testPair)
+	ts.terminate(&Termination{Reason: TERM_EXIT, ExitCode: 1})
+	ts.Close()
+	if got := ts.Termination(); got == nil || got.Reason != TERM_EXIT {
+		t.Errorf("Got:\n%v\nWanted:\n%s", got, TERM_EXIT)
+	}
+}
+
+func TestFailedNeverConnected(t *testing.T) {
+	up := newHostClient("up", nil, nil, nil, ReconnectSpec{})
+	up.connected = true
+	down := newHostClient("down", nil, nil, nil, ReconnectSpec{})
+	down.lastErr = errors.New("connection refused")
+	// The down host only has a glob, so it has no sessions of its own.
+	c := &ConsolidatedWriter{hosts: []*hostClient{up, down}}
+
+	if got := c.Failed(); len(got) != 1 || got[0] != "down" {
+		t.Errorf("Got:\n%v\nWanted:\n[down]", got)
+	}
+}
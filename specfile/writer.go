@@ -0,0 +1,200 @@
+/*
+Copyright © 2020 Joseph Saylor <doug@saylorsolutions.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package specfile
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// MAX_LINE_LENGTH is the longest line that's buffered, longer lines are split into pieces of this length.
+const MAX_LINE_LENGTH int = 64 * 1024
+
+// PARTIAL_LINE_TIMEOUT is how long the end of a line is held waiting for its newline before it's sent anyway.
+const PARTIAL_LINE_TIMEOUT time.Duration = 500 * time.Millisecond
+
+// TailChannelWriter splits what's written to it into lines, and sends each complete line to its channel as an Event
+// from the pair's file. A trailing partial line is sent once no more has been written for PARTIAL_LINE_TIMEOUT, or
+// when the writer is flushed.
+type TailChannelWriter struct {
+	pair     *ClientFilePair
+	ch       chan<- *Event
+	stream   string
+	mu       sync.Mutex
+	buf      []byte
+	timer    *time.Timer
+	backfill string
+	since    time.Time
+	last     time.Time
+	// lines and lastLine keep track of what was written to a stderr writer for the shutdown summary.
+	lines    int
+	lastLine string
+}
+
+// NewTailChannelWriter creates a TailChannelWriter that sends lines from the pair's file to the channel.
+func NewTailChannelWriter(pair *ClientFilePair, ch chan<- *Event) *TailChannelWriter {
+	return &TailChannelWriter{pair: pair, ch: ch}
+}
+
+// newStderrWriter creates a TailChannelWriter that sends what the pair's remote command writes to stderr to the
+// channel. Its lines are diagnostics, so they're sent as they are.
+func newStderrWriter(pair *ClientFilePair, ch chan<- *Event) *TailChannelWriter {
+	return &TailChannelWriter{pair: pair, ch: ch, stream: STREAM_STDERR}
+}
+
+// stderrSummary returns how many lines have been sent by a stderr writer, and the last of them.
+func (t *TailChannelWriter) stderrSummary() (int, string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.lines, t.lastLine
+}
+
+// backfillUntil marks lines as backfill until a line matching the marker is written. The marker line isn't sent. If
+// there's a start time, then backfill lines are dropped until one is found that was logged at or after it.
+func (t *TailChannelWriter) backfillUntil(marker string, since time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.backfill = marker
+	t.since = since
+}
+
+func (t *TailChannelWriter) Write(b []byte) (n int, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf = append(t.buf, b...)
+
+	start := 0
+	for {
+		i := bytes.IndexByte(t.buf[start:], '\n')
+		if i < 0 {
+			break
+		}
+		t.send(t.buf[start : start+i])
+		start += i + 1
+	}
+	for len(t.buf)-start >= MAX_LINE_LENGTH {
+		t.send(t.buf[start : start+MAX_LINE_LENGTH])
+		start += MAX_LINE_LENGTH
+	}
+	t.buf = append(t.buf[:0], t.buf[start:]...)
+
+	if len(t.buf) == 0 {
+		if t.timer != nil {
+			t.timer.Stop()
+		}
+	} else if t.timer == nil {
+		t.timer = time.AfterFunc(PARTIAL_LINE_TIMEOUT, t.Flush)
+	} else {
+		t.timer.Reset(PARTIAL_LINE_TIMEOUT)
+	}
+	return len(b), nil
+}
+
+// Flush sends any buffered partial line.
+func (t *TailChannelWriter) Flush() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	if len(t.buf) > 0 {
+		t.send(t.buf)
+		t.buf = t.buf[:0]
+	}
+}
+
+// send sends the line to the channel, splitting it if it's longer than MAX_LINE_LENGTH.
+func (t *TailChannelWriter) send(line []byte) {
+	line = bytes.TrimSuffix(line, []byte{'\r'})
+	if t.backfill != "" && string(line) == t.backfill {
+		t.backfill = ""
+		t.since = time.Time{}
+		return
+	}
+	for len(line) > MAX_LINE_LENGTH {
+		t.emit(line[:MAX_LINE_LENGTH])
+		line = line[MAX_LINE_LENGTH:]
+	}
+	t.emit(line)
+}
+
+// emit sends the event for the line, unless it's backfill from before the start time or it's dropped by a remote
+// filter that couldn't be applied on the host. Lines from containers that were already sent, because docker was
+// attached to the container again, are dropped too.
+func (t *TailChannelWriter) emit(line []byte) {
+	e := t.event(line)
+	if t.stream == STREAM_STDERR {
+		t.lines++
+		t.lastLine = e.Line
+		t.ch <- e
+		return
+	}
+	if t.pair.Spec.Source == SOURCE_DOCKER && !e.Logged.IsZero() {
+		if !e.Logged.After(t.last) {
+			return
+		}
+		t.last = e.Logged
+	}
+	if t.backfill != "" && !t.since.IsZero() {
+		ts, ok := e.Logged, !e.Logged.IsZero()
+		if !ok {
+			ts, ok = t.pair.Spec.Timestamp.Parse(e.Line, e.Time)
+		}
+		if !ok || ts.Before(t.since) {
+			return
+		}
+		t.since = time.Time{}
+	}
+	if !t.pair.filteredRemotely() && !t.pair.Spec.RemoteFilters.Match(e.Line) {
+		return
+	}
+	t.ch <- e
+}
+
+// event creates the event for the line, decoding it if it's from a source with structured output.
+func (t *TailChannelWriter) event(line []byte) *Event {
+	e := &Event{
+		Host:     t.pair.HostTag,
+		Tag:      t.pair.Tag,
+		Hostname: t.pair.Spec.Hostname,
+		File:     t.pair.File,
+		Time:     time.Now(),
+		Line:     string(line),
+		Backfill: t.backfill != "",
+		Stream:   t.stream,
+	}
+	if t.stream != "" {
+		return e
+	}
+	switch t.pair.Spec.Source {
+	case SOURCE_JOURNAL:
+		decodeJournal(e, len(t.pair.Spec.Journal.Unit) != 1)
+	case SOURCE_DOCKER:
+		decodeDocker(e, t.pair.Container)
+	}
+	return e
+}
+
+// newBackfillMarker creates a line that marks the end of backfill. It's random, so it won't be mistaken for a line in
+// the file.
+func newBackfillMarker() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "sshtail-backfill-" + hex.EncodeToString(b)
+}
@@ -0,0 +1,140 @@
+/*
+Copyright © 2020 Joseph Saylor <doug@saylorsolutions.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package specfile
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+var testPair *ClientFilePair = &ClientFilePair{HostTag: "host1", File: "/var/log/syslog", Tag: "host1", Spec: &HostSpec{Hostname: "remote-host-1"}}
+
+func receiveLines(ch chan *Event) []string {
+	lines := []string{}
+	for {
+		select {
+		case e := <-ch:
+			lines = append(lines, textFormatter{}.Format(e))
+		default:
+			return lines
+		}
+	}
+}
+
+func expectLines(t *testing.T, ch chan *Event, want ...string) {
+	t.Helper()
+	got := receiveLines(ch)
+	if strings.Join(got, "") != strings.Join(want, "") {
+		t.Errorf("Got:\n%q\nWanted:\n%q", got, want)
+	}
+}
+
+func TestWriterSplitsLines(t *testing.T) {
+	ch := make(chan *Event, 10)
+	w := NewTailChannelWriter(testPair, ch)
+	defer w.Flush()
+
+	w.Write([]byte("first line\nsecond "))
+	expectLines(t, ch, "[ host1 ] first line\n")
+	w.Write([]byte("line\r\nthird line\n"))
+	expectLines(t, ch, "[ host1 ] second line\n", "[ host1 ] third line\n")
+}
+
+func TestWriterFlushesPartialLine(t *testing.T) {
+	ch := make(chan *Event, 10)
+	w := NewTailChannelWriter(testPair, ch)
+
+	w.Write([]byte("no newline"))
+	expectLines(t, ch)
+	w.Flush()
+	expectLines(t, ch, "[ host1 ] no newline\n")
+
+	w.Write([]byte("waiting"))
+	select {
+	case e := <-ch:
+		if e.Line != "waiting" {
+			t.Errorf("Unexpected line after timeout: %q", e.Line)
+		}
+	case <-time.After(5 * PARTIAL_LINE_TIMEOUT):
+		t.Error("Partial line was not sent after the timeout")
+	}
+}
+
+func TestWriterMaxLineLength(t *testing.T) {
+	ch := make(chan *Event, 10)
+	w := NewTailChannelWriter(testPair, ch)
+	defer w.Flush()
+
+	long := strings.Repeat("a", MAX_LINE_LENGTH)
+	w.Write([]byte(long + "bc\n"))
+	expectLines(t, ch, "[ host1 ] "+long+"\n", "[ host1 ] bc\n")
+
+	w.Write([]byte(long + "d"))
+	expectLines(t, ch, "[ host1 ] "+long+"\n")
+	w.Flush()
+	expectLines(t, ch, "[ host1 ] d\n")
+}
+
+func TestWriterRemoteFilterSFTP(t *testing.T) {
+	spec := &HostSpec{Hostname: "remote-host-1", File: "/var/log/syslog", Mode: MODE_SFTP, RemoteFilters: FilterSpec{Exclude: []string{"DEBUG"}}}
+	if err := spec.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	pair := &ClientFilePair{HostTag: "host1", File: spec.File, Mode: spec.Mode, Tag: "host1", Spec: spec}
+	ch := make(chan *Event, 10)
+	w := NewTailChannelWriter(pair, ch)
+	defer w.Flush()
+
+	w.Write([]byte("INFO kept\nDEBUG dropped\nWARN kept\n"))
+	expectLines(t, ch, "[ host1 ] INFO kept\n", "[ host1 ] WARN kept\n")
+}
+
+func TestWriterBackfill(t *testing.T) {
+	ch := make(chan *Event, 10)
+	w := NewTailChannelWriter(testPair, ch)
+	defer w.Flush()
+	w.backfillUntil("marker", time.Time{})
+
+	w.Write([]byte("old line\nmarker\nnew line\n"))
+	for _, want := range []struct {
+		line     string
+		backfill bool
+	}{{"old line", true}, {"new line", false}} {
+		e := <-ch
+		if e.Line != want.line || e.Backfill != want.backfill {
+			t.Errorf("Got:\n%q %v\nWanted:\n%q %v", e.Line, e.Backfill, want.line, want.backfill)
+		}
+	}
+	expectLines(t, ch)
+}
+
+func TestWriterStderr(t *testing.T) {
+	spec := &HostSpec{Hostname: "remote-host-1", File: "/var/log/syslog", Mode: MODE_SFTP, RemoteFilters: FilterSpec{Include: []string{"INFO"}}}
+	if err := spec.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	pair := &ClientFilePair{HostTag: "host1", File: spec.File, Mode: spec.Mode, Tag: "host1", Spec: spec}
+	ch := make(chan *Event, 10)
+	w := newStderrWriter(pair, ch)
+	defer w.Flush()
+
+	w.Write([]byte("tail: cannot open '/var/log/syslog' for reading: Permission denied\ntail: no files remaining\n"))
+	expectLines(t, ch, "[ host1 !] tail: cannot open '/var/log/syslog' for reading: Permission denied\n", "[ host1 !] tail: no files remaining\n")
+	if n, last := w.stderrSummary(); n != 2 || last != "tail: no files remaining" {
+		t.Errorf("Got:\n%d %q\nWanted:\n2 \"tail: no files remaining\"", n, last)
+	}
+}